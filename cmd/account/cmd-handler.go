@@ -1,7 +1,9 @@
 package account
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -9,6 +11,11 @@ import (
 	dt "src.techknowlogick.com/shiori/database"
 )
 
+// readPassword reads a password from terminal without echo. Tests replace it to not need a terminal.
+var readPassword = func() ([]byte, error) {
+	return terminal.ReadPassword(int(syscall.Stdin))
+}
+
 // cmdHandler is handler for all action in AccountCmd
 type cmdHandler struct {
 	db dt.Database
@@ -28,7 +35,7 @@ func (h *cmdHandler) addAccount(cmd *cobra.Command, args []string) {
 
 	// Read and validate password
 	fmt.Print("Password: ")
-	bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+	bytePassword, err := readPassword()
 	if err != nil {
 		cError.Println(err)
 		return
@@ -42,20 +49,85 @@ func (h *cmdHandler) addAccount(cmd *cobra.Command, args []string) {
 	}
 
 	// Save account to database
-	err = h.db.CreateAccount(username, strPassword)
+	isAdmin, _ := cmd.Flags().GetBool("admin")
+	err = h.db.CreateAccount(context.Background(), username, strPassword, isAdmin)
 	if err != nil {
 		cError.Println(err)
 	}
 }
 
+// changePassword is handler for changing password of an account.
+// Accept exactly one argument, i.e. username.
+func (h *cmdHandler) changePassword(cmd *cobra.Command, args []string) {
+	username := args[0]
+	fmt.Println("Username: " + username)
+
+	// Read and validate new password
+	fmt.Print("New password: ")
+	bytePassword, err := readPassword()
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println()
+	strPassword := string(bytePassword)
+	if len(strPassword) < 8 {
+		cError.Println("Password must be at least 8 characters")
+		return
+	}
+
+	// Make sure there is no typo
+	fmt.Print("Confirm new password: ")
+	byteConfirm, err := readPassword()
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println()
+	if string(byteConfirm) != strPassword {
+		cError.Println("Passwords don't match")
+		return
+	}
+
+	// Save new password to database
+	err = h.db.UpdateAccountPassword(context.Background(), username, strPassword)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println("Password has been changed")
+}
+
+// setAccountAdmin is handler for granting or revoking admin role of an account.
+// Accept exactly one argument, i.e. username.
+func (h *cmdHandler) setAccountAdmin(cmd *cobra.Command, args []string) {
+	revoke, _ := cmd.Flags().GetBool("revoke")
+
+	err := h.db.SetAccountAdmin(context.Background(), args[0], !revoke)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	if revoke {
+		fmt.Println("Admin role revoked from " + args[0])
+	} else {
+		fmt.Println("Admin role granted to " + args[0])
+	}
+}
+
 // printAccounts is handler for showing all saved accounts.
 // Can be used to search accounts by using flag -search.
 func (h *cmdHandler) printAccounts(cmd *cobra.Command, args []string) {
 	// Parse flags
 	keyword, _ := cmd.Flags().GetString("search")
+	exactMatch, _ := cmd.Flags().GetBool("exact")
 
 	// Fetch list accounts in database
-	accounts, err := h.db.GetAccounts(keyword)
+	accounts, err := h.db.GetAccounts(context.Background(), keyword, exactMatch)
 	if err != nil {
 		cError.Println(err)
 		return
@@ -64,7 +136,11 @@ func (h *cmdHandler) printAccounts(cmd *cobra.Command, args []string) {
 	// Show list accounts
 	for _, account := range accounts {
 		cIndex.Print("- ")
-		fmt.Println(account.Username)
+		if account.IsAdmin {
+			fmt.Println(account.Username + " (admin)")
+		} else {
+			fmt.Println(account.Username)
+		}
 	}
 }
 
@@ -87,7 +163,12 @@ func (h *cmdHandler) deleteAccounts(cmd *cobra.Command, args []string) {
 	}
 
 	// Delete accounts in database
-	err := h.db.DeleteAccounts(args...)
+	var err error
+	if len(args) == 0 {
+		err = h.db.DeleteAllAccounts(context.Background())
+	} else {
+		err = h.db.DeleteAccounts(context.Background(), args...)
+	}
 	if err != nil {
 		cError.Println(err)
 		return
@@ -95,3 +176,75 @@ func (h *cmdHandler) deleteAccounts(cmd *cobra.Command, args []string) {
 
 	fmt.Println("Account(s) have been deleted")
 }
+
+// addAPIToken is handler for creating new API token for an account.
+// Accept exactly one argument, i.e. username.
+func (h *cmdHandler) addAPIToken(cmd *cobra.Command, args []string) {
+	label, _ := cmd.Flags().GetString("label")
+
+	account, err := h.db.GetAccount(context.Background(), args[0])
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+	if account.ID == 0 {
+		cError.Printf("Account %s doesn't exist\n", args[0])
+		return
+	}
+
+	token, err := h.db.CreateAPIToken(context.Background(), account.ID, label)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println(token)
+	fmt.Println("Save this token now, it can't be shown again")
+}
+
+// printAPITokens is handler for showing API tokens of an account.
+// Accept exactly one argument, i.e. username.
+func (h *cmdHandler) printAPITokens(cmd *cobra.Command, args []string) {
+	account, err := h.db.GetAccount(context.Background(), args[0])
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+	if account.ID == 0 {
+		cError.Printf("Account %s doesn't exist\n", args[0])
+		return
+	}
+
+	tokens, err := h.db.GetAPITokens(context.Background(), account.ID)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	for _, token := range tokens {
+		cIndex.Printf("%d. ", token.ID)
+		fmt.Printf("%s (created %s)\n", token.Label, token.Created.Format("2006-01-02"))
+	}
+}
+
+// deleteAPITokens is handler for revoking API tokens.
+// Accepts space-separated list of token IDs, as shown by token print.
+func (h *cmdHandler) deleteAPITokens(cmd *cobra.Command, args []string) {
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			cError.Printf("%s is not a valid token ID\n", arg)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	err := h.db.DeleteAPITokens(context.Background(), ids...)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println("Token(s) have been revoked")
+}
@@ -0,0 +1,75 @@
+package account
+
+import (
+	"context"
+	fp "path/filepath"
+	"testing"
+
+	dt "src.techknowlogick.com/shiori/database"
+)
+
+func TestAccountCommands(t *testing.T) {
+	db, err := dt.OpenSQLiteDatabase(fp.Join(t.TempDir(), "shiori.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// Every prompt is answered by the next password in the list
+	var passwords []string
+	defer func(read func() ([]byte, error)) { readPassword = read }(readPassword)
+	readPassword = func() ([]byte, error) {
+		password := passwords[0]
+		passwords = passwords[1:]
+		return []byte(password), nil
+	}
+
+	run := func(answers []string, args ...string) {
+		passwords = answers
+		cmd := NewAccountCmd(db)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("account %v: %v", args, err)
+		}
+	}
+
+	run([]string{"password"}, "add", "alice")
+	run([]string{"short"}, "add", "bob")
+	if _, err := db.VerifyAccount(ctx, "alice", "password"); err != nil {
+		t.Errorf("alice can't log in: %v", err)
+	}
+	if account, _ := db.GetAccount(ctx, "bob"); account.ID != 0 {
+		t.Error("bob is created with too short password")
+	}
+
+	steps := []struct {
+		name     string
+		answers  []string
+		password string
+	}{
+		{"mismatched confirmation", []string{"new password", "new pasword"}, "password"},
+		{"too short", []string{"short"}, "password"},
+		{"changed", []string{"new password", "new password"}, "new password"},
+	}
+	for _, step := range steps {
+		run(step.answers, "passwd", "alice")
+		if _, err := db.VerifyAccount(ctx, "alice", step.password); err != nil {
+			t.Errorf("%s: alice can't log in with %q: %v", step.name, step.password, err)
+		}
+	}
+
+	run(nil, "admin", "alice")
+	if account, _ := db.GetAccount(ctx, "alice"); !account.IsAdmin {
+		t.Error("alice isn't admin after admin command")
+	}
+	run(nil, "admin", "--revoke", "alice")
+	if account, _ := db.GetAccount(ctx, "alice"); account.IsAdmin {
+		t.Error("alice is still admin after admin --revoke")
+	}
+
+	run(nil, "delete", "alice")
+	if account, _ := db.GetAccount(ctx, "alice"); account.ID != 0 {
+		t.Error("alice still exists after delete")
+	}
+}
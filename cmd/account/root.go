@@ -24,6 +24,22 @@ func NewAccountCmd(db dt.Database) *cobra.Command {
 		Run:   hdl.addAccount,
 	}
 
+	passwdCmd := &cobra.Command{
+		Use:   "passwd username",
+		Short: "Change password of an account",
+		Args:  cobra.ExactArgs(1),
+		Run:   hdl.changePassword,
+	}
+
+	adminCmd := &cobra.Command{
+		Use:   "admin username",
+		Short: "Grant or revoke admin role of an account",
+		Long: "Grant admin role to an account. " +
+			"Admin can see and manage bookmarks of every account in web interface.",
+		Args: cobra.ExactArgs(1),
+		Run:  hdl.setAccountAdmin,
+	}
+
 	printCmd := &cobra.Command{
 		Use:     "print",
 		Short:   "Print the saved accounts",
@@ -41,9 +57,47 @@ func NewAccountCmd(db dt.Database) *cobra.Command {
 		Run: hdl.deleteAccounts,
 	}
 
+	tokenCmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens for accessing web API from scripts",
+	}
+
+	tokenAddCmd := &cobra.Command{
+		Use:   "add username",
+		Short: "Create new API token for an account",
+		Long: "Create new API token for an account. " +
+			"The token is sent in header \"Authorization: Bearer <token>\", " +
+			"and only shown once, so save it somewhere safe.",
+		Args: cobra.ExactArgs(1),
+		Run:  hdl.addAPIToken,
+	}
+
+	tokenPrintCmd := &cobra.Command{
+		Use:     "print username",
+		Short:   "Print API tokens of an account",
+		Args:    cobra.ExactArgs(1),
+		Aliases: []string{"list", "ls"},
+		Run:     hdl.printAPITokens,
+	}
+
+	tokenDeleteCmd := &cobra.Command{
+		Use:   "delete ids",
+		Short: "Revoke API tokens",
+		Long: "Revoke API tokens. " +
+			"Accepts space-separated list of token IDs, as shown by \"account token print\".",
+		Args: cobra.MinimumNArgs(1),
+		Run:  hdl.deleteAPITokens,
+	}
+
+	tokenCmd.AddCommand(tokenAddCmd, tokenPrintCmd, tokenDeleteCmd)
+
 	// Set sub command flags
+	addCmd.Flags().BoolP("admin", "a", false, "Create the account as admin")
+	adminCmd.Flags().BoolP("revoke", "r", false, "Revoke admin role instead of granting it")
 	printCmd.Flags().StringP("search", "s", "", "Search accounts by username")
+	printCmd.Flags().BoolP("exact", "e", false, "Only show account whose username exactly matches the search keyword")
 	deleteCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt and delete ALL accounts")
+	tokenAddCmd.Flags().StringP("label", "l", "", "Label to remember what the token is used for")
 
 	// Create final root command
 	rootCmd := &cobra.Command{
@@ -51,6 +105,6 @@ func NewAccountCmd(db dt.Database) *cobra.Command {
 		Short: "Manage account for accessing web interface",
 	}
 
-	rootCmd.AddCommand(addCmd, printCmd, deleteCmd)
+	rootCmd.AddCommand(addCmd, passwdCmd, adminCmd, printCmd, deleteCmd, tokenCmd)
 	return rootCmd
 }
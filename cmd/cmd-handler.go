@@ -1,26 +1,26 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
-	"math"
+	"io/ioutil"
+	"mime"
+	"net/http"
 	nurl "net/url"
 	"os"
 	fp "path/filepath"
-	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	valid "github.com/asaskevich/govalidator"
-	"github.com/go-shiori/go-readability"
-	"github.com/gofrs/uuid"
 	"github.com/gosuri/uiprogress"
 	"github.com/spf13/cobra"
 	dt "src.techknowlogick.com/shiori/database"
 	"src.techknowlogick.com/shiori/model"
+	"src.techknowlogick.com/shiori/readability"
 )
 
 // cmdHandler is handler for all action in AccountCmd
@@ -36,6 +36,7 @@ func (h *cmdHandler) addBookmark(cmd *cobra.Command, args []string) {
 	title, _ := cmd.Flags().GetString("title")
 	excerpt, _ := cmd.Flags().GetString("excerpt")
 	tags, _ := cmd.Flags().GetStringSlice("tags")
+	saveArchive, _ := cmd.Flags().GetBool("archive")
 
 	// Make sure URL valid
 	parsedURL, err := nurl.Parse(url)
@@ -44,9 +45,20 @@ func (h *cmdHandler) addBookmark(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// Clear fragment and UTM parameters from URL
-	parsedURL.Fragment = ""
-	clearUTMParams(parsedURL)
+	// Clear fragment from URL. Tracking parameters are removed when it is saved.
+	dt.ClearFragment(parsedURL)
+
+	// Make sure the URL is not saved yet, ignoring tracking parameters
+	duplicates, err := h.db.FindDuplicateBookmarks(context.Background(), parsedURL.String())
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	if len(duplicates) > 0 {
+		cError.Printf("URL already saved as bookmark %d\n", duplicates[0].ID)
+		return
+	}
 
 	// Create bookmark item
 	book := model.Bookmark{
@@ -62,21 +74,21 @@ func (h *cmdHandler) addBookmark(cmd *cobra.Command, args []string) {
 	}
 
 	// fetch data from internet
-	article, _ := readability.FromURL(parsedURL.String(), 20*time.Second)
+	page, data, mimeType, _ := readability.Download(book.URL)
 
-	book.Author = article.Byline
-	book.MinReadTime = int(math.Floor(float64(article.Length)/(987+188) + 0.5))
-	book.MaxReadTime = int(math.Floor(float64(article.Length)/(987-188) + 0.5))
-	book.Content = article.TextContent
-	book.HTML = article.Content
+	book.Author = page.Author
+	book.MinReadTime = page.MinReadTime
+	book.MaxReadTime = page.MaxReadTime
+	book.Content = page.Content
+	book.HTML = page.HTML
 
-	// If title and excerpt doesnt have submitted value, use from article
+	// If title and excerpt doesnt have submitted value, use from page
 	if book.Title == "" {
-		book.Title = article.Title
+		book.Title = page.Title
 	}
 
 	if book.Excerpt == "" {
-		book.Excerpt = strings.Map(fixUtf, article.Excerpt)
+		book.Excerpt = page.Excerpt
 	}
 
 	// Make sure title is not empty
@@ -84,32 +96,53 @@ func (h *cmdHandler) addBookmark(cmd *cobra.Command, args []string) {
 		book.Title = book.URL
 	}
 
-	// Save bookmark image to local disk
-	u2, err := uuid.NewV4()
-	if err != nil {
-		checkError(err)
-	}
-	imgPath := fp.Join(h.dataDir, "thumb", u2.String())
-	err = downloadFile(article.Image, imgPath, 20*time.Second)
-	if err == nil {
-		book.ImageURL = fmt.Sprintf("/thumb/%s", u2)
-	}
-
 	// Save bookmark to database
-	err = h.db.InsertBookmark(&book)
+	book.ImageURL = page.ImageURL
+	err = h.db.InsertBookmark(context.Background(), &book)
 	if err != nil {
 		cError.Println(err)
 		return
 	}
 
+	// Save bookmark image, so it's still shown when the remote image is gone
+	h.saveThumbnail(&book)
+
+	// Page that can't be extracted, e.g. PDF, can be saved as is, so it's still readable offline.
+	// Its type is detected from the data, since archives are shown by the web interface and
+	// server may claim that a page with scripts is a PDF.
+	if saveArchive && len(data) > 0 && !readability.IsHTML(mimeType) {
+		archiveType := http.DetectContentType(data)
+		if !archiveTypes[archiveType] {
+			cError.Printf("Archive is not saved, %s files can't be archived\n", mimeType)
+		} else if err = h.db.SaveArchive(context.Background(), book.ID, data, archiveType); err != nil {
+			cError.Println(err)
+		} else {
+			book.HasArchive = true
+		}
+	}
+
 	printBookmarks(book)
 }
 
+// archiveTypes are the types of files that may be saved as archive by addBookmark.
+// Other files, e.g. SVG and ZIP, may contain pages with scripts.
+var archiveTypes = map[string]bool{
+	"application/pdf": true,
+}
+
 // printBookmarks is handler for printing list of saved bookmarks
 func (h *cmdHandler) printBookmarks(cmd *cobra.Command, args []string) {
 	// Read flags
 	useJSON, _ := cmd.Flags().GetBool("json")
 	indexOnly, _ := cmd.Flags().GetBool("index-only")
+	untagged, _ := cmd.Flags().GetBool("untagged")
+	strOrder, _ := cmd.Flags().GetString("order")
+
+	order, err := dt.ParseOrder(strOrder)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
 
 	// Convert args to ids
 	ids, err := parseIndexList(args)
@@ -118,8 +151,18 @@ func (h *cmdHandler) printBookmarks(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if untagged && len(ids) > 0 {
+		cError.Println("Indices can't be used together with --untagged flag")
+		return
+	}
+
 	// Read bookmarks from database
-	bookmarks, err := h.db.GetBookmarks(false, ids...)
+	var bookmarks []model.Bookmark
+	if untagged {
+		bookmarks, err = h.db.GetUntaggedBookmarks(context.Background(), 0, false)
+	} else {
+		bookmarks, err = h.db.GetBookmarks(context.Background(), false, dt.ListOptions{OrderBy: order}, ids...)
+	}
 	if err != nil {
 		cError.Println(err)
 		return
@@ -128,6 +171,8 @@ func (h *cmdHandler) printBookmarks(cmd *cobra.Command, args []string) {
 	if len(bookmarks) == 0 {
 		if len(args) > 0 {
 			cError.Println("No matching index found")
+		} else if untagged {
+			cError.Println("No untagged bookmarks found")
 		} else {
 			cError.Println("No bookmarks saved yet")
 		}
@@ -163,6 +208,20 @@ func (h *cmdHandler) searchBookmarks(cmd *cobra.Command, args []string) {
 	tags, _ := cmd.Flags().GetStringSlice("tags")
 	useJSON, _ := cmd.Flags().GetBool("json")
 	indexOnly, _ := cmd.Flags().GetBool("index-only")
+	useTable, _ := cmd.Flags().GetBool("table")
+	latest, _ := cmd.Flags().GetBool("latest")
+	strOrder, _ := cmd.Flags().GetString("order")
+	strStartDate, _ := cmd.Flags().GetString("start-date")
+	strEndDate, _ := cmd.Flags().GetString("end-date")
+	prefix, _ := cmd.Flags().GetBool("prefix")
+	author, _ := cmd.Flags().GetString("author")
+	domain, _ := cmd.Flags().GetString("domain")
+
+	order, err := dt.ParseOrder(strOrder)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
 
 	// Fetch keyword
 	keyword := ""
@@ -170,8 +229,39 @@ func (h *cmdHandler) searchBookmarks(cmd *cobra.Command, args []string) {
 		keyword = args[0]
 	}
 
+	// Parse date range
+	var startDate, endDate time.Time
+	if strStartDate != "" {
+		startDate, err = time.ParseInLocation("2006-01-02", strStartDate, time.Local)
+		if err != nil {
+			cError.Println("Start date is not valid")
+			return
+		}
+	}
+
+	if strEndDate != "" {
+		endDate, err = time.ParseInLocation("2006-01-02", strEndDate, time.Local)
+		if err != nil {
+			cError.Println("End date is not valid")
+			return
+		}
+
+		// Include the whole end day
+		endDate = endDate.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
 	// Read bookmarks from database
-	bookmarks, err := h.db.SearchBookmarks(false, keyword, tags...)
+	bookmarks, _, err := h.db.SearchBookmarks(context.Background(), dt.SearchOptions{
+		ListOptions: dt.ListOptions{OrderBy: order},
+		OrderLatest: latest,
+		Keyword:     keyword,
+		Prefix:      prefix,
+		Tags:        tags,
+		Author:      author,
+		Domain:      domain,
+		StartDate:   startDate,
+		EndDate:     endDate,
+	})
 	if err != nil {
 		cError.Println(err)
 		return
@@ -202,6 +292,16 @@ func (h *cmdHandler) searchBookmarks(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if useTable {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTITLE\tURL")
+		for _, bookmark := range bookmarks {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", bookmark.ID, normalizeSpace(bookmark.Title), bookmark.URL)
+		}
+		w.Flush()
+		return
+	}
+
 	printBookmarks(bookmarks...)
 }
 
@@ -214,7 +314,9 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 	tags, _ := cmd.Flags().GetStringSlice("tags")
 	offline, _ := cmd.Flags().GetBool("offline")
 	skipConfirm, _ := cmd.Flags().GetBool("yes")
-	dontOverwrite := cmd.Flags().Changed("dont-overwrite")
+	updateAll, _ := cmd.Flags().GetBool("all")
+	keepMetadata, _ := cmd.Flags().GetBool("keep-metadata")
+	dontOverwrite := cmd.Flags().Changed("dont-overwrite") || keepMetadata
 
 	title = normalizeSpace(title)
 	excerpt = normalizeSpace(excerpt)
@@ -235,9 +337,8 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 			return
 		}
 
-		// Clear fragment and UTM parameters from URL
-		parsedURL.Fragment = ""
-		clearUTMParams(parsedURL)
+		// Clear fragment from URL. Tracking parameters are removed when it is saved.
+		dt.ClearFragment(parsedURL)
 		url = parsedURL.String()
 
 		// Make sure there is only one arguments
@@ -247,9 +348,14 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if updateAll && len(args) > 0 {
+		cError.Println("Indices can't be used together with --all flag")
+		return
+	}
+
 	// If no arguments (i.e all bookmarks will be updated),
-	// confirm to user
-	if len(args) == 0 && !skipConfirm {
+	// confirm to user, unless it's explicitly asked by --all
+	if len(args) == 0 && !skipConfirm && !updateAll {
 		confirmUpdate := ""
 		fmt.Print("Update ALL bookmarks? (y/n): ")
 		fmt.Scanln(&confirmUpdate)
@@ -265,7 +371,7 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 	wg := sync.WaitGroup{}
 
 	// Fetch bookmarks from database
-	bookmarks, err := h.db.GetBookmarks(true, ids...)
+	bookmarks, err := h.db.GetBookmarks(context.Background(), true, dt.ListOptions{}, ids...)
 	if err != nil {
 		cError.Println(err)
 		return
@@ -278,6 +384,7 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 
 	// If not offline, fetch articles from internet
 	listErrorMsg := []string{}
+	failedIDs := make(map[int]struct{})
 	if !offline {
 		fmt.Println("Fetching new bookmarks data")
 
@@ -306,44 +413,37 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 					mx.Lock()
 					errorMsg := fmt.Sprintf("Failed to fetch %s: URL is not valid", book.URL)
 					listErrorMsg = append(listErrorMsg, errorMsg)
+					failedIDs[book.ID] = struct{}{}
 					mx.Unlock()
 					return
 				}
 
 				// Fetch data from internet
-				article, err := readability.FromURL(parsedURL.String(), 20*time.Second)
+				page, err := readability.Fetch(parsedURL.String())
 				if err != nil {
 					mx.Lock()
 					errorMsg := fmt.Sprintf("Failed to fetch %s: %v", book.URL, err)
 					listErrorMsg = append(listErrorMsg, errorMsg)
+					failedIDs[book.ID] = struct{}{}
 					mx.Unlock()
 					return
 				}
 
-				book.Author = article.Byline
-				book.MinReadTime = int(math.Floor(float64(article.Length)/(987+188) + 0.5))
-				book.MaxReadTime = int(math.Floor(float64(article.Length)/(987-188) + 0.5))
-				book.Content = article.TextContent
-				book.HTML = article.Content
+				book.Author = page.Author
+				book.MinReadTime = page.MinReadTime
+				book.MaxReadTime = page.MaxReadTime
+				book.Content = page.Content
+				book.HTML = page.HTML
 
 				if !dontOverwrite {
-					book.Title = article.Title
-					book.Excerpt = article.Excerpt
+					book.Title = page.Title
+					book.Excerpt = page.Excerpt
 				}
 
-				// Save bookmark image to local disk
-				u2, err := uuid.NewV4()
-				if err != nil {
-					mx.Lock()
-					errorMsg := fmt.Sprintf("Failed generate uuid")
-					listErrorMsg = append(listErrorMsg, errorMsg)
-					mx.Unlock()
-					return
-				}
-				imgPath := fp.Join(h.dataDir, "thumb", u2.String())
-				err = downloadFile(article.Image, imgPath, 20*time.Second)
-				if err == nil {
-					book.ImageURL = fmt.Sprintf("/thumb/%s", u2)
+				// Update bookmark image
+				if page.ImageURL != "" {
+					book.ImageURL = page.ImageURL
+					h.saveThumbnail(&book)
 				}
 
 				// Update list of bookmarks
@@ -361,6 +461,20 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 		for _, errorMsg := range listErrorMsg {
 			cError.Println(errorMsg + "\n")
 		}
+
+		// Bookmarks that failed to fetch are skipped, so the rest still updated
+		fetchedBookmarks := make([]model.Bookmark, 0, len(bookmarks))
+		for _, book := range bookmarks {
+			if _, failed := failedIDs[book.ID]; !failed {
+				fetchedBookmarks = append(fetchedBookmarks, book)
+			}
+		}
+		bookmarks = fetchedBookmarks
+
+		if len(bookmarks) == 0 {
+			cError.Println("No bookmarks updated")
+			return
+		}
 	}
 
 	// Map the tags to be added or deleted from flag --tags
@@ -424,7 +538,7 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 	}
 
 	// Update database
-	result, err := h.db.UpdateBookmarks(bookmarks...)
+	result, err := h.db.UpdateBookmarks(context.Background(), bookmarks...)
 	if err != nil {
 		cError.Println(err)
 		return
@@ -438,12 +552,42 @@ func (h *cmdHandler) updateBookmarks(cmd *cobra.Command, args []string) {
 func (h *cmdHandler) deleteBookmarks(cmd *cobra.Command, args []string) {
 	// Parse flags
 	skipConfirm, _ := cmd.Flags().GetBool("yes")
+	purge, _ := cmd.Flags().GetBool("purge")
+	tagName, _ := cmd.Flags().GetString("tag")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	// If tag submitted, delete all bookmarks with that tag
+	if tagName != "" {
+		if len(args) > 0 {
+			cError.Println("Indices can't be used together with --tag flag")
+			return
+		}
+
+		h.deleteBookmarksByTag(tagName, purge, skipConfirm, dryRun)
+		return
+	}
+
+	// In dry run, only show the bookmarks that would be deleted
+	if dryRun {
+		ids, err := parseIndexList(args)
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		h.printDeleteTargets(ids, purge)
+		return
+	}
 
 	// If no arguments (i.e all bookmarks going to be deleted),
 	// confirm to user
 	if len(args) == 0 && !skipConfirm {
 		confirmDelete := ""
-		fmt.Print("Remove ALL bookmarks? (y/n): ")
+		if purge {
+			fmt.Print("Permanently remove ALL bookmarks in trash? (y/n): ")
+		} else {
+			fmt.Print("Remove ALL bookmarks? (y/n): ")
+		}
 		fmt.Scanln(&confirmDelete)
 
 		if confirmDelete != "y" {
@@ -459,10 +603,27 @@ func (h *cmdHandler) deleteBookmarks(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// Delete bookmarks from database
-	err = h.db.DeleteBookmarks(ids...)
+	// Move bookmarks to trash, so they still can be restored
+	if !purge {
+		if len(ids) == 0 {
+			err = h.db.DeleteAllBookmarks(context.Background())
+		} else {
+			err = h.db.DeleteBookmarks(context.Background(), ids...)
+		}
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		fmt.Println("Bookmark(s) have been moved to trash")
+		return
+	}
+
+	// Permanently delete bookmarks from database
+	err = h.db.PurgeBookmarks(context.Background(), ids...)
 	if err != nil {
 		cError.Println(err)
+		return
 	}
 
 	// Delete thumbnail image from local disk
@@ -474,10 +635,130 @@ func (h *cmdHandler) deleteBookmarks(cmd *cobra.Command, args []string) {
 	fmt.Println("Bookmark(s) have been deleted")
 }
 
+// deleteBookmarksByTag moves all bookmarks with matching tag to trash,
+// or permanently deletes them if purge is true.
+func (h *cmdHandler) deleteBookmarksByTag(tagName string, purge, skipConfirm, dryRun bool) {
+	// Without a valid name, the search would match every bookmark
+	tagName = dt.NormalizeTagName(tagName)
+	if tagName == "" {
+		cError.Println("Tag name must not be empty")
+		return
+	}
+
+	bookmarks, _, err := h.db.SearchBookmarks(context.Background(), dt.SearchOptions{
+		Tags: []string{tagName},
+	})
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	if len(bookmarks) == 0 {
+		cError.Printf("No bookmarks with tag %s\n", tagName)
+		return
+	}
+
+	if dryRun {
+		printBookmarks(bookmarks...)
+		fmt.Printf("%d bookmark(s) would be deleted\n", len(bookmarks))
+		return
+	}
+
+	if !skipConfirm {
+		confirmDelete := ""
+		fmt.Printf("Remove %d bookmark(s) with tag %s? (y/n): ", len(bookmarks), tagName)
+		fmt.Scanln(&confirmDelete)
+
+		if confirmDelete != "y" {
+			fmt.Println("No bookmarks deleted")
+			return
+		}
+	}
+
+	ids := make([]int, 0, len(bookmarks))
+	for _, book := range bookmarks {
+		ids = append(ids, book.ID)
+	}
+
+	if !purge {
+		err = h.db.DeleteBookmarks(context.Background(), ids...)
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		fmt.Printf("%d bookmark(s) have been moved to trash\n", len(ids))
+		return
+	}
+
+	err = h.db.PurgeBookmarks(context.Background(), ids...)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Printf("%d bookmark(s) have been deleted\n", len(ids))
+}
+
+// printDeleteTargets prints the bookmarks that deleteBookmarks would delete, without deleting them.
+// If no ids given, it's all saved bookmarks, or all bookmarks in trash if purge is true.
+func (h *cmdHandler) printDeleteTargets(ids []int, purge bool) {
+	opts := dt.ListOptions{IncludeDeleted: purge}
+	bookmarks, err := h.db.GetBookmarks(context.Background(), false, opts, ids...)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	// Purging without ids only removes bookmarks that already in trash
+	if purge && len(ids) == 0 {
+		trashed := make([]model.Bookmark, 0, len(bookmarks))
+		for _, book := range bookmarks {
+			if !book.DeletedAt.IsZero() {
+				trashed = append(trashed, book)
+			}
+		}
+		bookmarks = trashed
+	}
+
+	printBookmarks(bookmarks...)
+	fmt.Printf("%d bookmark(s) would be deleted\n", len(bookmarks))
+}
+
+// restoreBookmarks is handler for restoring bookmarks from trash
+func (h *cmdHandler) restoreBookmarks(cmd *cobra.Command, args []string) {
+	// Convert args to ids
+	ids, err := parseIndexList(args)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	err = h.db.RestoreBookmarks(context.Background(), ids...)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println("Bookmark(s) have been restored")
+}
+
+// recomputeReadTimes is handler for estimating reading time of all bookmarks again
+func (h *cmdHandler) recomputeReadTimes(cmd *cobra.Command, args []string) {
+	nUpdated, err := h.db.RecomputeReadTimes(context.Background())
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Printf("Reading time of %d bookmark(s) have been updated\n", nUpdated)
+}
+
 // openBookmarks is handler for opening bookmarks
 func (h *cmdHandler) openBookmarks(cmd *cobra.Command, args []string) {
 	// Parse flags
 	cacheMode, _ := cmd.Flags().GetBool("cache")
+	archiveMode, _ := cmd.Flags().GetBool("archive")
 	trimSpace, _ := cmd.Flags().GetBool("trim-space")
 	skipConfirm, _ := cmd.Flags().GetBool("yes")
 
@@ -501,7 +782,7 @@ func (h *cmdHandler) openBookmarks(cmd *cobra.Command, args []string) {
 	}
 
 	// Fetch bookmarks from database
-	bookmarks, err := h.db.GetBookmarks(true, ids...)
+	bookmarks, err := h.db.GetBookmarks(context.Background(), true, dt.ListOptions{}, ids...)
 	if err != nil {
 		cError.Println(err)
 		return
@@ -516,6 +797,30 @@ func (h *cmdHandler) openBookmarks(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	// Tell which of the submitted indices don't exist
+	if len(ids) > 0 {
+		found := make(map[int]struct{}, len(bookmarks))
+		for _, book := range bookmarks {
+			found[book.ID] = struct{}{}
+		}
+		for _, id := range ids {
+			if _, ok := found[id]; !ok {
+				cError.Printf("No bookmark with index %d\n", id)
+			}
+		}
+	}
+
+	// In archive mode, open the archived copy of bookmarks in browser
+	if archiveMode {
+		for _, book := range bookmarks {
+			err = h.openArchive(book)
+			if err != nil {
+				cError.Printf("Failed to open archive of %s: %v\n", book.URL, err)
+			}
+		}
+		return
+	}
+
 	// If not cache mode, open bookmarks in browser
 	if !cacheMode {
 		for _, book := range bookmarks {
@@ -555,255 +860,46 @@ func (h *cmdHandler) openBookmarks(cmd *cobra.Command, args []string) {
 	}
 }
 
-// importBookmarks is handler for importing bookmarks.
-// Accept exactly one argument, the file to be imported.
-func (h *cmdHandler) importBookmarks(cmd *cobra.Command, args []string) {
-	// Parse flags
-	generateTag := cmd.Flags().Changed("generate-tag")
-
-	// If user doesn't specify, ask if tag need to be generated
-	if !generateTag {
-		var submit string
-		fmt.Print("Add parents folder as tag? (y/n): ")
-		fmt.Scanln(&submit)
-
-		generateTag = submit == "y"
-	}
-
-	// Open bookmark's file
-	srcFile, err := os.Open(args[0])
-	if err != nil {
-		cError.Println(err)
-		return
-	}
-	defer srcFile.Close()
-
-	// Parse bookmark's file
-	doc, err := goquery.NewDocumentFromReader(srcFile)
-	if err != nil {
-		cError.Println(err)
-		return
-	}
-
-	bookmarks := []model.Bookmark{}
-	doc.Find("dt>a").Each(func(_ int, a *goquery.Selection) {
-		// Get related elements
-		dt := a.Parent()
-		dl := dt.Parent()
-
-		// Get metadata
-		title := a.Text()
-		url, _ := a.Attr("href")
-		strTags, _ := a.Attr("tags")
-		strModified, _ := a.Attr("last_modified")
-		intModified, _ := strconv.ParseInt(strModified, 10, 64)
-		modified := time.Unix(intModified, 0)
-
-		// Make sure URL valid
-		parsedURL, err := nurl.Parse(url)
-		if err != nil || !valid.IsRequestURL(url) {
-			cError.Printf("%s will be skipped: URL is not valid\n\n", url)
-			return
-		}
-
-		// Clear fragment and UTM parameters from URL
-		parsedURL.Fragment = ""
-		clearUTMParams(parsedURL)
-
-		// Get bookmark tags
-		tags := []model.Tag{}
-		for _, strTag := range strings.Split(strTags, ",") {
-			if strTag != "" {
-				tags = append(tags, model.Tag{Name: strTag})
-			}
-		}
-
-		// Get bookmark excerpt
-		excerpt := ""
-		if dd := dt.Next(); dd.Is("dd") {
-			excerpt = dd.Text()
-		}
-
-		// Get category name for this bookmark
-		// and add it as tags (if necessary)
-		category := ""
-		if dtCategory := dl.Prev(); dtCategory.Is("h3") {
-			category = dtCategory.Text()
-			category = normalizeSpace(category)
-			category = strings.ToLower(category)
-			category = strings.Replace(category, " ", "-", -1)
-		}
-
-		if category != "" && generateTag {
-			tags = append(tags, model.Tag{Name: category})
-		}
-
-		// Add item to list
-		bookmark := model.Bookmark{
-			URL:      parsedURL.String(),
-			Title:    normalizeSpace(title),
-			Excerpt:  normalizeSpace(excerpt),
-			Modified: modified,
-			Tags:     tags,
-		}
-
-		bookmarks = append(bookmarks, bookmark)
-	})
-
-	// Save bookmarks to database
-	for _, book := range bookmarks {
-		// Save book to database
-		err = h.db.InsertBookmark(&book)
-		if err != nil {
-			cError.Printf("%s is skipped: %v\n\n", book.URL, err)
-			continue
-		}
-
-		printBookmarks(book)
-	}
-}
-
-// exportBookmarks is handler for exporting bookmarks.
-// Accept exactly one argument, the file to be exported.
-func (h *cmdHandler) exportBookmarks(cmd *cobra.Command, args []string) {
-	// Fetch bookmarks from database
-	bookmarks, err := h.db.GetBookmarks(false)
+// openArchive writes the archived copy of bookmark to a temporary file, then opens it in browser.
+func (h *cmdHandler) openArchive(book model.Bookmark) error {
+	data, mimeType, err := h.db.GetArchive(context.Background(), book.ID)
 	if err != nil {
-		cError.Println(err)
-		return
+		return err
 	}
 
-	if len(bookmarks) == 0 {
-		cError.Println("No saved bookmarks yet")
-		return
+	ext := ".html"
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		ext = exts[0]
 	}
 
-	// Make sure destination directory exist
-	dstDir := fp.Dir(args[0])
-	os.MkdirAll(dstDir, os.ModePerm)
-
-	// Open destination file
-	dstFile, err := os.Create(args[0])
+	f, err := ioutil.TempFile("", fmt.Sprintf("shiori-%d-*%s", book.ID, ext))
 	if err != nil {
-		cError.Println(err)
-		return
+		return err
 	}
-	defer dstFile.Close()
+	defer f.Close()
 
-	// Create template
-	funcMap := template.FuncMap{
-		"unix": func(str string) int64 {
-			t, err := time.Parse("2006-01-02 15:04:05", str)
-			if err != nil {
-				return time.Now().Unix()
-			}
-
-			return t.Unix()
-		},
-		"combine": func(tags []model.Tag) string {
-			strTags := make([]string, len(tags))
-			for i, tag := range tags {
-				strTags[i] = tag.Name
-			}
-
-			return strings.Join(strTags, ",")
-		},
+	if _, err = f.Write(data); err != nil {
+		return err
 	}
 
-	tplContent := `<!DOCTYPE NETSCAPE-Bookmark-file-1>` +
-		`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">` +
-		`<TITLE>Bookmarks</TITLE>` +
-		`<H1>Bookmarks</H1>` +
-		`<DL><p>` +
-		`{{range $book := .}}` +
-		`<DT><A HREF="{{$book.URL}}" ADD_DATE="{{unix $book.Modified}}" TAGS="{{combine $book.Tags}}">{{$book.Title}}</A>` +
-		`{{if gt (len $book.Excerpt) 0}}<DD>{{$book.Excerpt}}{{end}}{{end}}` +
-		`</DL><p>`
-
-	tpl, err := template.New("export").Funcs(funcMap).Parse(tplContent)
-	if err != nil {
-		cError.Println(err)
-		return
-	}
-
-	// Execute template
-	err = tpl.Execute(dstFile, &bookmarks)
-	if err != nil {
-		cError.Println(err)
-		return
-	}
-
-	fmt.Println("Export finished")
+	return openBrowser(f.Name())
 }
 
-// importPockets is handler for importing bookmarks from Pocket exported HTML file.
-// Accept exactly one argument, the file to be imported.
-func (h *cmdHandler) importPockets(cmd *cobra.Command, args []string) {
-	// Open bookmark's file
-	srcFile, err := os.Open(args[0])
-	if err != nil {
-		cError.Println(err)
+// saveThumbnail downloads the image of a saved bookmark to database. If the download failed,
+// e.g. because the image is too big, the bookmark keeps using its remote image.
+func (h *cmdHandler) saveThumbnail(book *model.Bookmark) {
+	if book.ImageURL == "" {
 		return
 	}
-	defer srcFile.Close()
 
-	// Parse bookmark's file
-	doc, err := goquery.NewDocumentFromReader(srcFile)
+	data, mimeType, err := readability.DownloadImage(book.ImageURL, dt.MaxThumbnailSize, 20*time.Second)
 	if err != nil {
-		cError.Println(err)
 		return
 	}
 
-	bookmarks := []model.Bookmark{}
-	doc.Find("a").Each(func(_ int, a *goquery.Selection) {
-		// Get metadata
-		title := a.Text()
-		url, _ := a.Attr("href")
-		strTags, _ := a.Attr("tags")
-		strModified, _ := a.Attr("time_added")
-		intModified, _ := strconv.ParseInt(strModified, 10, 64)
-		modified := time.Unix(intModified, 0)
-
-		// Make sure URL valid
-		parsedURL, err := nurl.Parse(url)
-		if err != nil || !valid.IsRequestURL(url) {
-			cError.Printf("%s will be skipped: URL is not valid\n\n", url)
-			return
-		}
-
-		// Clear fragment and UTM parameters from URL
-		parsedURL.Fragment = ""
-		clearUTMParams(parsedURL)
-
-		// Get bookmark tags
-		tags := []model.Tag{}
-		for _, strTag := range strings.Split(strTags, ",") {
-			if strTag != "" {
-				tags = append(tags, model.Tag{Name: strTag})
-			}
-		}
-
-		// Add item to list
-		bookmark := model.Bookmark{
-			URL:      parsedURL.String(),
-			Title:    normalizeSpace(title),
-			Modified: modified,
-			Tags:     tags,
-		}
-
-		bookmarks = append(bookmarks, bookmark)
-	})
-
-	// Save bookmarks to database
-	for _, book := range bookmarks {
-		// Save book to database
-		err = h.db.InsertBookmark(&book)
-		if err != nil {
-			cError.Printf("%s is skipped: %v\n\n", book.URL, err)
-			continue
-		}
-
-		printBookmarks(book)
+	err = h.db.SaveThumbnail(context.Background(), book.ID, data, mimeType)
+	if err == nil {
+		book.HasThumbnail = true
 	}
 }
 
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// newTestHandler creates command handler backed by a new SQLite database,
+// which is removed after the test together with the data directory.
+func newTestHandler(t *testing.T) *cmdHandler {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	db, err := dt.OpenSQLiteDatabase(fp.Join(dataDir, "shiori.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &cmdHandler{db: db, dataDir: dataDir}
+}
+
+// runCommand runs shiori with args against the database of h,
+// and returns what it printed to stdout.
+func runCommand(t *testing.T, h *cmdHandler, args ...string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read while the command runs, so big output doesn't block it
+	output := make(chan string)
+	go func() {
+		bt, _ := ioutil.ReadAll(r)
+		output <- string(bt)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	cmd := NewShioriCmd(h.db, h.dataDir)
+	cmd.SetArgs(args)
+	err = cmd.Execute()
+	w.Close()
+	if err != nil {
+		t.Fatalf("shiori %s: %v", strings.Join(args, " "), err)
+	}
+
+	return <-output
+}
+
+func TestSearchTable(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, book := range []model.Bookmark{
+		{URL: "https://example.com/old", Title: "Old   Go\narticle", Tags: []model.Tag{{Name: "go"}}},
+		{URL: "https://example.com/new", Title: "New Go article", Tags: []model.Tag{{Name: "go"}}},
+		{URL: "https://example.com/rust", Title: "Rust article", Tags: []model.Tag{{Name: "rust"}}},
+	} {
+		if err := h.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both are created in the same second, so make the first one older
+	past := time.Now().Add(-time.Hour).Format("2006-01-02 15:04:05")
+	if _, err := h.db.(*dt.XormDatabase).Exec("UPDATE bookmark SET created = ? WHERE id = 1", past); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(strings.TrimSpace(runCommand(t, h, "search", "-t", "go", "--latest", "--table")), "\n")
+	want := []string{
+		"ID  TITLE           URL",
+		"2   New Go article  https://example.com/new",
+		"1   Old Go article  https://example.com/old",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got table\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got := runCommand(t, h, "search", "-t", "go", "--index-only"); got != "1 2 \n" {
+		t.Errorf("got indices %q, want %q", got, "1 2 \n")
+	}
+}
+
+func TestOpenBookmarks(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, url := range []string{"https://example.com/first", "https://example.com/second"} {
+		book := model.Bookmark{URL: url, Title: url}
+		if err := h.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.db.SaveArchive(ctx, 2, []byte("%PDF-1.4"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	var opened []string
+	defer func(open func(string) error) { openBrowser = open }(openBrowser)
+	openBrowser = func(url string) error {
+		opened = append(opened, url)
+		return nil
+	}
+
+	// Unknown index 3 is reported, without stopping the others
+	runCommand(t, h, "open", "1", "3")
+	if len(opened) != 1 || opened[0] != "https://example.com/first" {
+		t.Errorf("got opened %q, want only the first bookmark", opened)
+	}
+
+	opened = nil
+	runCommand(t, h, "open", "--archive", "2")
+	if len(opened) != 1 || !strings.HasSuffix(opened[0], ".pdf") {
+		t.Fatalf("got opened %q, want the archive as PDF file", opened)
+	}
+	defer os.Remove(opened[0])
+
+	data, err := ioutil.ReadFile(opened[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "%PDF-1.4" {
+		t.Errorf("got archive %q, want %q", data, "%PDF-1.4")
+	}
+}
+
+func TestUpdateBookmarks(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, url := range []string{server.URL + "/article.html", server.URL + "/missing.html"} {
+		book := model.Bookmark{URL: url, Title: "Old title", Content: "Old content"}
+		if err := h.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getBookmark := func(id int) model.Bookmark {
+		book, _, err := h.db.GetBookmark(ctx, id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return book
+	}
+
+	// Only the content is refreshed when metadata is kept
+	runCommand(t, h, "update", "--keep-metadata", "1")
+	if book := getBookmark(1); book.Title != "Old title" || !strings.Contains(book.Content, "update command downloads this page") {
+		t.Errorf("got title %q and content %q, want old title with fetched content", book.Title, book.Content)
+	}
+
+	// Indices and --all are exclusive, so nothing is updated
+	runCommand(t, h, "update", "--all", "--offline", "--title", "Ignored", "2")
+	if book := getBookmark(2); book.Title != "Old title" {
+		t.Errorf("got title %q, want bookmark not updated", book.Title)
+	}
+
+	// The missing page fails to fetch, but the other bookmark is still updated
+	runCommand(t, h, "update", "--all", "--tags", "fetched")
+	if book := getBookmark(1); book.Title != "Fetched article" || book.Excerpt != "Excerpt of the fetched article" {
+		t.Errorf("got title %q and excerpt %q, want them from the page", book.Title, book.Excerpt)
+	} else if got := strings.Join(tagNames(book.Tags), ","); got != "fetched" {
+		t.Errorf("got tags %q, want fetched", got)
+	}
+	if book := getBookmark(2); book.Title != "Old title" || book.Content != "Old content" || len(book.Tags) != 0 {
+		t.Errorf("got %q with content %q and tags %v, want it unchanged", book.Title, book.Content, tagNames(book.Tags))
+	}
+}
+
+func TestAddBookmarkArchive(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	h := newTestHandler(t)
+	runCommand(t, h, "add", "--archive", server.URL+"/paper.pdf")
+	runCommand(t, h, "add", "--archive", server.URL+"/logo.svg")
+
+	bookmarks, err := h.db.GetBookmarks(context.Background(), false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 {
+		t.Fatalf("got %d bookmarks, want 2", len(bookmarks))
+	}
+
+	// SVG may run scripts when the archive is shown, so only the PDF is archived
+	for _, book := range bookmarks {
+		wantArchive := strings.HasSuffix(book.URL, ".pdf")
+		if book.HasArchive != wantArchive {
+			t.Errorf("%s: got archive %v, want %v", book.URL, book.HasArchive, wantArchive)
+		}
+		if !wantArchive {
+			continue
+		}
+
+		if _, mimeType, err := h.db.GetArchive(context.Background(), book.ID); err != nil || mimeType != "application/pdf" {
+			t.Errorf("got archive of type %q with error %v, want PDF", mimeType, err)
+		}
+	}
+}
+
+func TestRecomputeReadTimes(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	// Read time of older versions was estimated from characters instead of words
+	book := model.Bookmark{
+		URL:         "https://example.com/long",
+		Title:       "Long read",
+		Content:     strings.Repeat("word ", 520),
+		MinReadTime: 7,
+		MaxReadTime: 9,
+	}
+	if err := h.db.InsertBookmark(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runCommand(t, h, "read-time")
+	if !strings.Contains(output, "1 bookmark(s)") {
+		t.Errorf("got output %q, want 1 updated bookmark", output)
+	}
+
+	saved, _, err := h.db.GetBookmark(ctx, book.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.MinReadTime != 2 || saved.MaxReadTime != 3 {
+		t.Errorf("got read time %d-%d, want 2-3", saved.MinReadTime, saved.MaxReadTime)
+	}
+}
+
+func TestDeleteBookmarksByTag(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct{ url, tag string }{
+		{"https://example.com/old/1", "old"},
+		{"https://example.com/keep", "keep"},
+		{"https://example.com/old/2", "old"},
+		{"https://example.com/temp", "temp"},
+	} {
+		book := model.Bookmark{URL: tt.url, Title: tt.url, Tags: []model.Tag{{Name: tt.tag}}}
+		if err := h.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// urls returns the URL of every saved bookmark, marking the ones in trash
+	urls := func() []string {
+		bookmarks, err := h.db.GetBookmarks(ctx, false, dt.ListOptions{IncludeDeleted: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, book := range bookmarks {
+			if book.DeletedAt.IsZero() {
+				result = append(result, book.URL)
+			} else {
+				result = append(result, book.URL+" (trash)")
+			}
+		}
+		return result
+	}
+
+	steps := []struct {
+		args []string
+		want []string
+	}{
+		{
+			[]string{"delete", "--tag", "keep", "2"},
+			[]string{"https://example.com/old/1", "https://example.com/keep", "https://example.com/old/2", "https://example.com/temp"},
+		},
+		{
+			[]string{"delete", "--tag", "old", "--yes"},
+			[]string{"https://example.com/old/1 (trash)", "https://example.com/keep", "https://example.com/old/2 (trash)", "https://example.com/temp"},
+		},
+		{
+			[]string{"delete", "--tag", "temp", "--purge", "--yes"},
+			[]string{"https://example.com/old/1 (trash)", "https://example.com/keep", "https://example.com/old/2 (trash)"},
+		},
+	}
+
+	for _, step := range steps {
+		runCommand(t, h, step.args...)
+		if got := urls(); strings.Join(got, "\n") != strings.Join(step.want, "\n") {
+			t.Errorf("after %v got\n%s\nwant\n%s", step.args, strings.Join(got, "\n"), strings.Join(step.want, "\n"))
+		}
+	}
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	for i, tag := range []string{"old", "old", "keep", "trashed"} {
+		book := model.Bookmark{URL: fmt.Sprintf("https://example.com/%d", i), Title: "Example", Tags: []model.Tag{{Name: tag}}}
+		if err := h.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.db.DeleteBookmarks(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		n    int
+	}{
+		{[]string{"delete", "--dry-run"}, 3},
+		{[]string{"delete", "--dry-run", "1", "3"}, 2},
+		{[]string{"delete", "--dry-run", "--tag", "old"}, 2},
+		{[]string{"delete", "--dry-run", "--purge"}, 1},
+		{[]string{"delete", "--dry-run", "--purge", "4"}, 1},
+	}
+
+	for _, tt := range tests {
+		output := runCommand(t, h, tt.args...)
+		if want := fmt.Sprintf("%d bookmark(s) would be deleted", tt.n); !strings.Contains(output, want) {
+			t.Errorf("%v: got output %q, want %q", tt.args, output, want)
+		}
+	}
+
+	bookmarks, err := h.db.GetBookmarks(ctx, false, dt.ListOptions{IncludeDeleted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range bookmarks {
+		if book.ID != 4 && !book.DeletedAt.IsZero() {
+			t.Errorf("bookmark %d is moved to trash by dry run", book.ID)
+		}
+	}
+	if len(bookmarks) != 4 {
+		t.Errorf("got %d bookmarks after dry run, want all 4", len(bookmarks))
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// exportBookmarks is handler for exporting bookmarks.
+// Accept at most one argument, the file to be exported.
+// If there are no arguments, bookmarks are written to stdout.
+func (h *cmdHandler) exportBookmarks(cmd *cobra.Command, args []string) {
+	// Parse flags
+	format, _ := cmd.Flags().GetString("format")
+
+	var export func(io.Writer, []model.Bookmark) error
+	switch format {
+	case "netscape":
+		export = exportNetscape
+	case "json":
+		export = exportJSON
+	case "csv":
+		export = exportCSV
+	default:
+		cError.Printf("Format %s is not supported\n", format)
+		return
+	}
+
+	// Fetch bookmarks from database, content is only needed for full backup
+	withContent := format == "json"
+	bookmarks, err := h.db.GetBookmarks(context.Background(), withContent, dt.ListOptions{})
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	if len(bookmarks) == 0 {
+		cError.Println("No saved bookmarks yet")
+		return
+	}
+
+	// Write to stdout if target file is not specified
+	if len(args) == 0 || args[0] == "-" {
+		err = export(os.Stdout, bookmarks)
+		if err != nil {
+			cError.Println(err)
+		}
+		return
+	}
+
+	// Make sure destination directory exist
+	dstDir := fp.Dir(args[0])
+	os.MkdirAll(dstDir, os.ModePerm)
+
+	// Open destination file
+	dstFile, err := os.Create(args[0])
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+	defer dstFile.Close()
+
+	err = export(dstFile, bookmarks)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Println("Export finished")
+}
+
+// exportNetscape writes bookmarks as HTML file in Netscape Bookmark format,
+// which can be imported by browsers. Tags are saved in TAGS attribute.
+func exportNetscape(w io.Writer, bookmarks []model.Bookmark) error {
+	// Create template
+	funcMap := template.FuncMap{
+		"unix": func(t time.Time) int64 {
+			if t.IsZero() {
+				return time.Now().Unix()
+			}
+
+			return t.Unix()
+		},
+		"combine": func(tags []model.Tag) string {
+			strTags := make([]string, len(tags))
+			for i, tag := range tags {
+				strTags[i] = tag.Name
+			}
+
+			return strings.Join(strTags, ",")
+		},
+	}
+
+	tplContent := `<!DOCTYPE NETSCAPE-Bookmark-file-1>` + "\n" +
+		`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">` + "\n" +
+		`<TITLE>Bookmarks</TITLE>` + "\n" +
+		`<H1>Bookmarks</H1>` + "\n" +
+		`<DL><p>` + "\n" +
+		`{{range $book := .}}` +
+		`<DT><A HREF="{{$book.URL}}" ADD_DATE="{{unix $book.Modified}}" TAGS="{{combine $book.Tags}}">{{$book.Title}}</A>` + "\n" +
+		`{{if gt (len $book.Excerpt) 0}}<DD>{{$book.Excerpt}}` + "\n" + `{{end}}{{end}}` +
+		`</DL><p>` + "\n"
+
+	tpl, err := template.New("export").Funcs(funcMap).Parse(tplContent)
+	if err != nil {
+		return err
+	}
+
+	// Execute template
+	return tpl.Execute(w, &bookmarks)
+}
+
+// exportJSON writes bookmarks as JSON array, including their tags, content and HTML.
+// The result can be imported back to shiori by importJSON.
+func exportJSON(w io.Writer, bookmarks []model.Bookmark) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&bookmarks)
+}
+
+// exportCSV writes bookmarks as CSV with columns url, title, excerpt, author, tags and modified.
+// Tags are joined by semicolon, since tag name may contain space.
+func exportCSV(w io.Writer, bookmarks []model.Bookmark) error {
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write([]string{"url", "title", "excerpt", "author", "tags", "modified"})
+	if err != nil {
+		return err
+	}
+
+	for _, book := range bookmarks {
+		tagNames := make([]string, len(book.Tags))
+		for i, tag := range book.Tags {
+			tagNames[i] = tag.Name
+		}
+
+		err = csvWriter.Write([]string{
+			book.URL,
+			book.Title,
+			book.Excerpt,
+			book.Author,
+			strings.Join(tagNames, ";"),
+			book.Modified.Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
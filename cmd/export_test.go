@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// savedBookmarks returns "url title [tags]" of every bookmark in database of h, by ID.
+func savedBookmarks(t *testing.T, h *cmdHandler) []string {
+	t.Helper()
+
+	bookmarks, err := h.db.GetBookmarks(context.Background(), false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([]string, len(bookmarks))
+	for i, book := range bookmarks {
+		result[i] = fmt.Sprintf("%s %s %v", book.URL, book.Title, tagNames(book.Tags))
+	}
+	return result
+}
+
+// tagNames returns the names of tags.
+func tagNames(tags []model.Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
+
+func TestExportNetscapeRoundTrip(t *testing.T) {
+	src := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, book := range []model.Bookmark{
+		{URL: "https://example.com/", Title: "Example", Tags: []model.Tag{{Name: "example"}, {Name: "test"}}},
+		{URL: "https://example.com/search?a=1&b=2", Title: `Fish & Chips <"quoted">`, Excerpt: "Excerpt"},
+	} {
+		if err := src.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bookmarks, err := src.db.GetBookmarks(ctx, false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := exportNetscape(&buf, bookmarks); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newTestHandler(t)
+	if _, _, err := dst.importNetscape(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := savedBookmarks(t, src), savedBookmarks(t, dst)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q after round trip, want %q", got, want)
+	}
+}
+
+func TestExportJSONRoundTrip(t *testing.T) {
+	src := newTestHandler(t)
+	ctx := context.Background()
+
+	for _, book := range []model.Bookmark{
+		{URL: "https://example.com/first", Title: "First", Content: "Cached content", HTML: "<p>Cached content</p>", Tags: []model.Tag{{Name: "cached"}}},
+		{URL: "https://example.com/second", Title: "Second", Excerpt: "Excerpt", Note: "My note", Favorite: true},
+	} {
+		if err := src.db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bookmarks, err := src.db.GetBookmarks(ctx, true, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := exportJSON(&buf, bookmarks); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.String()
+
+	dst := newTestHandler(t)
+	imported, skipped, err := dst.importJSON(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 || skipped != 0 {
+		t.Errorf("got %d imported and %d skipped, want 2 and 0", imported, skipped)
+	}
+
+	restored, err := dst.db.GetBookmarks(ctx, true, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(bookmarks) {
+		t.Fatalf("got %d restored bookmarks, want %d", len(restored), len(bookmarks))
+	}
+	for i, book := range restored {
+		want := bookmarks[i]
+		if book.URL != want.URL || book.Title != want.Title || book.Excerpt != want.Excerpt ||
+			book.Content != want.Content || book.HTML != want.HTML || book.Note != want.Note ||
+			book.Favorite != want.Favorite || !book.Modified.Equal(want.Modified) {
+			t.Errorf("got restored bookmark %+v, want %+v", book, want)
+		}
+		if fmt.Sprint(tagNames(book.Tags)) != fmt.Sprint(tagNames(want.Tags)) {
+			t.Errorf("got tags %v of %s, want %v", tagNames(book.Tags), book.URL, tagNames(want.Tags))
+		}
+	}
+
+	// Importing again skips the saved bookmarks, unless they're overwritten
+	imported, skipped, err = dst.importJSON(bytes.NewBufferString(exported), false)
+	if err != nil || imported != 0 || skipped != 2 {
+		t.Errorf("got %d imported and %d skipped again, err %v, want all skipped", imported, skipped, err)
+	}
+	imported, _, err = dst.importJSON(bytes.NewBufferString(exported), true)
+	if err != nil || imported != 2 {
+		t.Errorf("got %d overwritten bookmarks, err %v, want 2", imported, err)
+	}
+}
+
+func TestExportCSVEscaping(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	bookmarks := []model.Bookmark{{
+		URL:      "https://example.com/?a=1,2",
+		Title:    `Hello, "World"`,
+		Excerpt:  "First line\nsecond line",
+		Tags:     []model.Tag{{Name: "go"}, {Name: "web dev"}},
+		Modified: modified,
+	}}
+
+	var buf bytes.Buffer
+	if err := exportCSV(&buf, bookmarks); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Hello, ""World"""`) {
+		t.Errorf("title isn't quoted in %q", buf.String())
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("exported CSV can't be read: %v", err)
+	}
+
+	want := [][]string{
+		{"url", "title", "excerpt", "author", "tags", "modified"},
+		{"https://example.com/?a=1,2", `Hello, "World"`, "First line\nsecond line", "", "go;web dev", "2020-01-02T03:04:05Z"},
+	}
+	if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", want) {
+		t.Errorf("got records %q, want %q", records, want)
+	}
+}
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	nurl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/spf13/cobra"
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// importBookmarks is handler for importing bookmarks.
+// Accept exactly one argument, the file to be imported.
+func (h *cmdHandler) importBookmarks(cmd *cobra.Command, args []string) {
+	// Parse flags
+	format, _ := cmd.Flags().GetString("format")
+	overwrite, _ := cmd.Flags().GetBool("overwrite")
+	generateTag := cmd.Flags().Changed("generate-tag")
+
+	if format != "netscape" && format != "json" {
+		cError.Printf("Format %s is not supported\n", format)
+		return
+	}
+
+	// If user doesn't specify, ask if tag need to be generated
+	if format == "netscape" && !generateTag {
+		var submit string
+		fmt.Print("Add parents folder as tag? (y/n): ")
+		fmt.Scanln(&submit)
+
+		generateTag = submit == "y"
+	}
+
+	// Open bookmark's file
+	srcFile, err := os.Open(args[0])
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+	defer srcFile.Close()
+
+	// Import bookmarks
+	var imported, skipped int
+	if format == "json" {
+		imported, skipped, err = h.importJSON(srcFile, overwrite)
+	} else {
+		imported, skipped, err = h.importNetscape(srcFile, generateTag)
+	}
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Printf("Import finished: %d imported, %d skipped\n", imported, skipped)
+}
+
+// importNetscape saves bookmarks from HTML file in Netscape Bookmark format to database.
+// If generateTag is true, the folders that contain a bookmark are added as its tags.
+// Bookmarks whose URL already saved in database are skipped.
+func (h *cmdHandler) importNetscape(r io.Reader, generateTag bool) (imported, skipped int, err error) {
+	// Parse bookmark's file
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bookmarks := []model.Bookmark{}
+	doc.Find("dt>a").Each(func(_ int, a *goquery.Selection) {
+		// Get related elements
+		term := a.Parent()
+
+		// Get metadata
+		title := a.Text()
+		url, _ := a.Attr("href")
+		strTags, _ := a.Attr("tags")
+		strAdded, _ := a.Attr("add_date")
+		if strAdded == "" {
+			strAdded, _ = a.Attr("last_modified")
+		}
+
+		// Make sure URL valid
+		parsedURL, err := nurl.Parse(url)
+		if err != nil || !valid.IsRequestURL(url) {
+			cError.Printf("%s will be skipped: URL is not valid\n\n", url)
+			skipped++
+			return
+		}
+
+		// Clear fragment from URL. Tracking parameters are removed when it is saved.
+		dt.ClearFragment(parsedURL)
+
+		// Get bookmark tags
+		tags := []model.Tag{}
+		for _, strTag := range strings.Split(strTags, ",") {
+			if strTag != "" {
+				tags = append(tags, model.Tag{Name: strTag})
+			}
+		}
+
+		// Get bookmark excerpt
+		excerpt := ""
+		if dd := term.Next(); dd.Is("dd") {
+			excerpt = dd.Text()
+		}
+
+		// Get name of every folder that contains this bookmark,
+		// from the nearest one, and add it as tags (if necessary)
+		if generateTag {
+			a.ParentsFiltered("dl").Each(func(_ int, dl *goquery.Selection) {
+				if h3 := dl.Prev(); h3.Is("h3") {
+					category := normalizeSpace(h3.Text())
+					category = strings.ToLower(category)
+					category = strings.Replace(category, " ", "-", -1)
+					if category != "" {
+						tags = append(tags, model.Tag{Name: category})
+					}
+				}
+			})
+		}
+
+		// Add item to list
+		bookmark := model.Bookmark{
+			URL:     parsedURL.String(),
+			Title:   normalizeSpace(title),
+			Excerpt: normalizeSpace(excerpt),
+			Tags:    tags,
+		}
+
+		if intAdded, err := strconv.ParseInt(strAdded, 10, 64); err == nil && intAdded > 0 {
+			bookmark.Modified = time.Unix(intAdded, 0)
+		}
+
+		bookmarks = append(bookmarks, bookmark)
+	})
+
+	// Save bookmarks to database
+	nImported, nSkipped := h.insertImportedBookmarks(bookmarks)
+	return nImported, skipped + nSkipped, nil
+}
+
+// importPockets is handler for importing bookmarks from Pocket exported HTML file.
+// Accept exactly one argument, the file to be imported.
+func (h *cmdHandler) importPockets(cmd *cobra.Command, args []string) {
+	// Open bookmark's file
+	srcFile, err := os.Open(args[0])
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+	defer srcFile.Close()
+
+	// Import bookmarks
+	imported, skipped, err := h.importPocket(srcFile)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Printf("Import finished: %d imported, %d skipped\n", imported, skipped)
+}
+
+// importPocket saves bookmarks from Pocket exported HTML file to database.
+// Bookmarks in Pocket's archive are marked as read, and the ones marked
+// as favorite in Pocket are saved as favorite.
+// Bookmarks whose URL already saved in database are skipped.
+func (h *cmdHandler) importPocket(r io.Reader) (imported, skipped int, err error) {
+	// Parse bookmark's file
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bookmarks := []model.Bookmark{}
+	doc.Find("a").Each(func(_ int, a *goquery.Selection) {
+		// Get metadata
+		title := a.Text()
+		url, _ := a.Attr("href")
+		strTags, _ := a.Attr("tags")
+		strModified, _ := a.Attr("time_added")
+		intModified, _ := strconv.ParseInt(strModified, 10, 64)
+		strFavorite, _ := a.Attr("favorite")
+
+		// Make sure URL valid
+		parsedURL, err := nurl.Parse(url)
+		if err != nil || !valid.IsRequestURL(url) {
+			cError.Printf("%s will be skipped: URL is not valid\n\n", url)
+			skipped++
+			return
+		}
+
+		// Clear fragment from URL. Tracking parameters are removed when it is saved.
+		dt.ClearFragment(parsedURL)
+
+		// Get bookmark tags
+		tags := []model.Tag{}
+		for _, strTag := range strings.Split(strTags, ",") {
+			strTag = strings.TrimSpace(strTag)
+			if strTag != "" {
+				tags = append(tags, model.Tag{Name: strTag})
+			}
+		}
+
+		// Pocket puts archived items in a list under "Read Archive" heading
+		section := a.Closest("ul").Prev()
+		archived := section.Is("h1") && strings.EqualFold(normalizeSpace(section.Text()), "Read Archive")
+
+		// Add item to list
+		bookmark := model.Bookmark{
+			URL:      parsedURL.String(),
+			Title:    normalizeSpace(title),
+			Tags:     tags,
+			Read:     archived,
+			Favorite: strFavorite == "1" || strings.EqualFold(strFavorite, "true"),
+		}
+
+		// Time added is optional, database uses current time if it's missing
+		if intModified > 0 {
+			bookmark.Modified = time.Unix(intModified, 0)
+		}
+
+		bookmarks = append(bookmarks, bookmark)
+	})
+
+	// Save bookmarks to database
+	nImported, nSkipped := h.insertImportedBookmarks(bookmarks)
+	return nImported, skipped + nSkipped, nil
+}
+
+// insertImportedBookmarks saves new bookmarks to database and prints them.
+// Bookmarks whose URL already saved in database are skipped.
+func (h *cmdHandler) insertImportedBookmarks(bookmarks []model.Bookmark) (imported, skipped int) {
+	// Skip bookmarks that already saved, including the ones listed twice in the file
+	newBookmarks := make([]model.Bookmark, 0, len(bookmarks))
+	savedURLs := make(map[string]struct{})
+	for _, book := range bookmarks {
+		book.URL = dt.NormalizeURL(book.URL)
+		_, saved := savedURLs[book.URL]
+		if saved || h.db.GetBookmarkID(context.Background(), book.URL) != 0 {
+			cError.Printf("%s is skipped: URL already exists\n\n", book.URL)
+			skipped++
+			continue
+		}
+
+		savedURLs[book.URL] = struct{}{}
+		newBookmarks = append(newBookmarks, book)
+	}
+
+	// Save all bookmarks at once, showing the progress since it may take a while
+	_, err := h.db.InsertBookmarks(context.Background(), newBookmarks, printImportProgress)
+	if err == nil {
+		printBookmarks(newBookmarks...)
+		return len(newBookmarks), skipped
+	}
+
+	// If it failed, save them one by one so only the invalid bookmarks are skipped.
+	// The progress line hasn't been finished, so end it first.
+	fmt.Fprintln(os.Stderr)
+	for _, book := range newBookmarks {
+		book.ID = 0
+		err := h.db.InsertBookmark(context.Background(), &book)
+		if err != nil {
+			cError.Printf("%s is skipped: %v\n\n", book.URL, err)
+			skipped++
+			continue
+		}
+
+		printBookmarks(book)
+		imported++
+	}
+
+	return imported, skipped
+}
+
+// printImportProgress prints the number of saved bookmarks, overwriting the previous line.
+// Progress is printed to stderr, so it doesn't mix with the output of saved bookmarks.
+func printImportProgress(done, total int) {
+	fmt.Fprintf(os.Stderr, "\rSaving bookmarks: %d/%d", done, total)
+	if done == total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// importJSON saves bookmarks from JSON file created by exportJSON to database.
+// Bookmarks whose URL already saved in database are skipped,
+// unless overwrite is true in which case the saved bookmarks are updated.
+func (h *cmdHandler) importJSON(r io.Reader, overwrite bool) (imported, skipped int, err error) {
+	bookmarks := []model.Bookmark{}
+	err = json.NewDecoder(r).Decode(&bookmarks)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, book := range bookmarks {
+		// Check if bookmark already saved
+		book.URL = dt.NormalizeURL(book.URL)
+		book.ID = h.db.GetBookmarkID(context.Background(), book.URL)
+		if book.ID != 0 && !overwrite {
+			cError.Printf("%s is skipped: URL already exists\n\n", book.URL)
+			skipped++
+			continue
+		}
+
+		if book.ID != 0 {
+			var result []model.Bookmark
+			result, err = h.db.UpdateBookmarks(context.Background(), book)
+			if err == nil {
+				book = result[0]
+			}
+		} else {
+			err = h.db.InsertBookmark(context.Background(), &book)
+		}
+
+		if err != nil {
+			cError.Printf("%s is skipped: %v\n\n", book.URL, err)
+			skipped++
+			continue
+		}
+
+		printBookmarks(book)
+		imported++
+	}
+
+	return imported, skipped, nil
+}
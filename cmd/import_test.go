@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestImportNetscape(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	// Bookmarks that already saved are skipped
+	saved := model.Bookmark{URL: "https://example.com/", Title: "Saved"}
+	if err := h.db.InsertBookmark(ctx, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("testdata/bookmarks.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	imported, skipped, err := h.importNetscape(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 1 || skipped != 2 {
+		t.Errorf("got %d imported and %d skipped, want 1 and 2", imported, skipped)
+	}
+
+	bookmarks, err := h.db.GetBookmarks(ctx, false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 {
+		t.Fatalf("got %d bookmarks, want the saved and the imported one", len(bookmarks))
+	}
+
+	book := bookmarks[1]
+	if book.URL != "https://golang.org/doc/" {
+		t.Errorf("got URL %s, want it without UTM parameters", book.URL)
+	}
+	if book.Title != "The Go Programming Language" || book.Excerpt != "Documentation of Go" {
+		t.Errorf("got title %q and excerpt %q", book.Title, book.Excerpt)
+	}
+	if want := time.Unix(1546300800, 0); book.Modified.Unix() != want.Unix() {
+		t.Errorf("got modified %v, want %v from ADD_DATE", book.Modified, want)
+	}
+
+	if got := strings.Join(tagNames(book.Tags), ","); got != "dev-tools,go" {
+		t.Errorf("got tags %s, want the folder and TAGS attribute", got)
+	}
+
+	if saved, _, _ := h.db.GetBookmark(ctx, saved.ID, false); saved.Title != "Saved" {
+		t.Errorf("saved bookmark is overwritten by import")
+	}
+}
+
+func TestImportPocket(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	f, err := os.Open("testdata/pocket.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	imported, skipped, err := h.importPocket(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 3 || skipped != 0 {
+		t.Errorf("got %d imported and %d skipped, want 3 and 0", imported, skipped)
+	}
+
+	bookmarks, err := h.db.GetBookmarks(ctx, false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url      string
+		title    string
+		tags     string
+		read     bool
+		modified int64
+	}{
+		{"https://example.com/article", "Unread article", "news,tech", false, 1546300800},
+		{"https://example.com/no-date", "Article without date", "", false, 0},
+		{"https://example.com/archived", "Archived article", "news", true, 1546214400},
+	}
+	if len(bookmarks) != len(tests) {
+		t.Fatalf("got %d bookmarks, want %d", len(bookmarks), len(tests))
+	}
+
+	for i, tt := range tests {
+		book := bookmarks[i]
+		if book.URL != tt.url || book.Title != tt.title || book.Read != tt.read {
+			t.Errorf("got %s %q read %v, want %s %q read %v", book.URL, book.Title, book.Read, tt.url, tt.title, tt.read)
+		}
+		if got := strings.Join(tagNames(book.Tags), ","); got != tt.tags {
+			t.Errorf("%s: got tags %q, want %q", tt.url, got, tt.tags)
+		}
+
+		// Without time_added, the time of import is used
+		if tt.modified == 0 && time.Since(book.Modified) > time.Hour {
+			t.Errorf("%s: got modified %v, want the time of import", tt.url, book.Modified)
+		} else if tt.modified != 0 && book.Modified.Unix() != tt.modified {
+			t.Errorf("%s: got modified %v, want %v", tt.url, book.Modified, time.Unix(tt.modified, 0))
+		}
+	}
+}
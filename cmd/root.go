@@ -4,6 +4,7 @@ import (
 	"github.com/spf13/cobra"
 	"src.techknowlogick.com/shiori/cmd/account"
 	"src.techknowlogick.com/shiori/cmd/serve"
+	"src.techknowlogick.com/shiori/cmd/tag"
 	dt "src.techknowlogick.com/shiori/database"
 )
 
@@ -39,8 +40,9 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 		Long: "Search bookmarks by looking for matching keyword in bookmark's title and content. " +
 			"If no keyword submitted, print all saved bookmarks. " +
 			"Search results will be different depending on DBMS that used by shiori :\n" +
-			"- sqlite3, search works using fts4 method: https://www.sqlite.org/fts3.html.\n" +
-			"- mysql or mariadb, search works using natural language mode: https://dev.mysql.com/doc/refman/5.5/en/fulltext-natural-language.html.",
+			"- sqlite3, title and content are matched using fts4 method: https://www.sqlite.org/fts3.html.\n" +
+			"- postgres, title and content are matched using full text search: https://www.postgresql.org/docs/current/textsearch.html.\n" +
+			"- other DBMS, title and content are matched using LIKE pattern.",
 		Args: cobra.MaximumNArgs(1),
 		Run:  hdl.searchBookmarks,
 	}
@@ -61,12 +63,21 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 		Use:   "delete [indices]",
 		Short: "Delete the saved bookmarks",
 		Long: "Delete bookmarks. " +
-			"When a record is deleted, the last record is moved to the removed index. " +
+			"Deleted bookmarks are moved to trash and can be restored, unless --purge flag is used. " +
 			"Accepts space-separated list of indices (e.g. 5 6 23 4 110 45), hyphenated range (e.g. 100-200) or both (e.g. 1-3 7 9). " +
-			"If no arguments, all records will be deleted.",
+			"If no arguments, all records will be deleted, or with --purge, all records in trash.",
 		Run: hdl.deleteBookmarks,
 	}
 
+	restoreCmd := &cobra.Command{
+		Use:   "restore [indices]",
+		Short: "Restore the deleted bookmarks from trash",
+		Long: "Restore bookmarks that moved to trash by delete command. " +
+			"Accepts space-separated list of indices (e.g. 5 6 23 4 110 45), hyphenated range (e.g. 100-200) or both (e.g. 1-3 7 9). " +
+			"If no arguments, all records in trash will be restored.",
+		Run: hdl.restoreBookmarks,
+	}
+
 	openCmd := &cobra.Command{
 		Use:   "open [indices]",
 		Short: "Open the saved bookmarks",
@@ -79,15 +90,31 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 	importCmd := &cobra.Command{
 		Use:   "import source-file",
 		Short: "Import bookmarks from HTML file in Netscape Bookmark format",
-		Args:  cobra.ExactArgs(1),
-		Run:   hdl.importBookmarks,
+		Long: "Import bookmarks from HTML file in Netscape Bookmark format, " +
+			"or from JSON file created by \"shiori export --format json\".",
+		Args: cobra.ExactArgs(1),
+		Run:  hdl.importBookmarks,
 	}
 
 	exportCmd := &cobra.Command{
-		Use:   "export target-file",
+		Use:   "export [target-file]",
 		Short: "Export bookmarks into HTML file in Netscape Bookmark format",
-		Args:  cobra.ExactArgs(1),
-		Run:   hdl.exportBookmarks,
+		Long: "Export all bookmarks into HTML file in Netscape Bookmark format, " +
+			"which can be imported by web browsers. " +
+			"Use JSON format to backup the complete bookmarks, including their cached content, " +
+			"or CSV format to analyze them in spreadsheet. " +
+			"If target file is not specified or is \"-\", bookmarks are written to stdout.",
+		Args: cobra.MaximumNArgs(1),
+		Run:  hdl.exportBookmarks,
+	}
+
+	readTimeCmd := &cobra.Command{
+		Use:   "read-time",
+		Short: "Recompute the reading time of the saved bookmarks",
+		Long: "Estimate the reading time of all bookmarks again from their saved content, " +
+			"e.g. for bookmarks that saved by older version of shiori.",
+		Args: cobra.NoArgs,
+		Run:  hdl.recomputeReadTimes,
 	}
 
 	pocketCmd := &cobra.Command{
@@ -100,19 +127,31 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 	// Create sub command that has its own sub command
 	accountCmd := account.NewAccountCmd(db)
 	serveCmd := serve.NewServeCmd(db, dataDir)
+	tagCmd := tag.NewTagCmd(db)
 
 	// Set sub command flags
 	addCmd.Flags().StringP("title", "i", "", "Custom title for this bookmark.")
 	addCmd.Flags().StringP("excerpt", "e", "", "Custom excerpt for this bookmark.")
 	addCmd.Flags().StringSliceP("tags", "t", []string{}, "Comma-separated tags for this bookmark.")
 	addCmd.Flags().BoolP("offline", "o", false, "Save bookmark without fetching data from internet.")
+	addCmd.Flags().BoolP("archive", "a", false, "Save copy of the file as archive if the URL is a PDF file.")
 
 	printCmd.Flags().BoolP("json", "j", false, "Output data in JSON format")
 	printCmd.Flags().BoolP("index-only", "i", false, "Only print the index of bookmarks")
+	printCmd.Flags().BoolP("untagged", "u", false, "Only print bookmarks that don't have any tags")
+	printCmd.Flags().StringP("order", "o", "", "Sort bookmarks by id, title, modified, created or read-time. Prefix it with - to sort in descending order")
 
 	searchCmd.Flags().BoolP("json", "j", false, "Output data in JSON format")
 	searchCmd.Flags().BoolP("index-only", "i", false, "Only print the index of bookmarks")
+	searchCmd.Flags().Bool("table", false, "Print the id, title and url of bookmarks as a table")
+	searchCmd.Flags().BoolP("latest", "l", false, "Sort the newest bookmarks first")
+	searchCmd.Flags().StringP("order", "o", "", "Sort bookmarks by id, title, modified, created, read-time or relevance. Prefix it with - to sort in descending order")
 	searchCmd.Flags().StringSliceP("tags", "t", []string{}, "Search bookmarks with specified tag(s)")
+	searchCmd.Flags().String("author", "", "Search bookmarks written by this author")
+	searchCmd.Flags().String("domain", "", "Search bookmarks saved from this domain, e.g. example.com")
+	searchCmd.Flags().Bool("prefix", false, "Also match words that start with the keyword, e.g. golan matches golang")
+	searchCmd.Flags().String("start-date", "", "Search bookmarks modified on or after this date (YYYY-MM-DD)")
+	searchCmd.Flags().String("end-date", "", "Search bookmarks modified on or before this date (YYYY-MM-DD)")
 
 	updateCmd.Flags().StringP("url", "u", "", "New URL for this bookmark.")
 	updateCmd.Flags().StringP("title", "i", "", "New title for this bookmark.")
@@ -120,15 +159,25 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 	updateCmd.Flags().StringSliceP("tags", "t", []string{}, "Comma-separated tags for this bookmark.")
 	updateCmd.Flags().BoolP("offline", "o", false, "Update bookmark without fetching data from internet.")
 	updateCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt and update ALL bookmarks")
+	updateCmd.Flags().BoolP("keep-metadata", "k", false, "Keep the existing title and excerpt, only update bookmark's content.")
 	updateCmd.Flags().Bool("dont-overwrite", false, "Don't overwrite existing metadata. Useful when only want to update bookmark's content.")
+	updateCmd.Flags().BoolP("all", "a", false, "Update ALL bookmarks without confirmation prompt")
 
 	deleteCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt and delete ALL bookmarks")
+	deleteCmd.Flags().Bool("purge", false, "Permanently delete the bookmarks instead of moving them to trash")
+	deleteCmd.Flags().StringP("tag", "t", "", "Delete all bookmarks with this tag")
+	deleteCmd.Flags().Bool("dry-run", false, "Only print the bookmarks that would be deleted")
 
 	openCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt and open ALL bookmarks")
 	openCmd.Flags().BoolP("cache", "c", false, "Open the bookmark's cache in text-only mode")
+	openCmd.Flags().BoolP("archive", "a", false, "Open the bookmark's archived copy instead of the live URL")
 	openCmd.Flags().Bool("trim-space", false, "Trim all spaces and newlines from the bookmark's cache")
 
 	importCmd.Flags().BoolP("generate-tag", "t", false, "Auto generate tag from bookmark's category")
+	importCmd.Flags().StringP("format", "f", "netscape", "Format of the source file, either netscape or json")
+	importCmd.Flags().Bool("overwrite", false, "Update bookmarks that already saved instead of skipping them (json format only)")
+
+	exportCmd.Flags().StringP("format", "f", "netscape", "Format of the target file, either netscape, json or csv")
 
 	// Create final root command
 	rootCmd := &cobra.Command{
@@ -136,7 +185,7 @@ func NewShioriCmd(db dt.Database, dataDir string) *cobra.Command {
 		Short: "Simple command-line bookmark manager built with Go",
 	}
 
-	rootCmd.AddCommand(accountCmd, serveCmd, addCmd, printCmd, searchCmd,
-		updateCmd, deleteCmd, openCmd, importCmd, exportCmd, pocketCmd)
+	rootCmd.AddCommand(accountCmd, serveCmd, tagCmd, addCmd, printCmd, searchCmd,
+		updateCmd, deleteCmd, restoreCmd, openCmd, importCmd, exportCmd, pocketCmd, readTimeCmd)
 	return rootCmd
 }
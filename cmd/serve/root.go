@@ -1,9 +1,13 @@
 package serve
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -30,29 +34,7 @@ func NewServeCmd(db dt.Database, dataDir string) *cobra.Command {
 			port, _ := cmd.Flags().GetInt("port")
 
 			// Create router
-			router := httprouter.New()
-
-			router.GET("/dist/*filepath", hdl.serveFiles)
-
-			router.GET("/", hdl.serveIndexPage)
-			router.GET("/login", hdl.serveLoginPage)
-			router.GET("/bookmark/:id", hdl.serveBookmarkCache)
-			router.GET("/thumb/:id", hdl.serveThumbnailImage)
-			router.GET("/submit", hdl.serveSubmitPage)
-
-			router.POST("/api/login", hdl.apiLogin)
-			router.GET("/api/bookmarks", hdl.apiGetBookmarks)
-			router.GET("/api/tags", hdl.apiGetTags)
-			router.POST("/api/bookmarks", hdl.apiInsertBookmark)
-			router.PUT("/api/cache", hdl.apiUpdateCache)
-			router.PUT("/api/bookmarks", hdl.apiUpdateBookmark)
-			router.PUT("/api/bookmarks/tags", hdl.apiUpdateBookmarkTags)
-			router.DELETE("/api/bookmarks", hdl.apiDeleteBookmark)
-
-			// Route for panic
-			router.PanicHandler = func(w http.ResponseWriter, r *http.Request, arg interface{}) {
-				http.Error(w, fmt.Sprint(arg), 500)
-			}
+			router := newRouter(hdl)
 
 			// Create server
 			url := fmt.Sprintf("%s:%d", listenAddress, port)
@@ -63,9 +45,33 @@ func NewServeCmd(db dt.Database, dataDir string) *cobra.Command {
 				WriteTimeout: 20 * time.Second,
 			}
 
+			// Stop gracefully when interrupted, so running requests can finish
+			// before the database is closed
+			serverStopped := make(chan struct{})
+			go func() {
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+				<-signals
+
+				logrus.Infoln("Shutting down shiori")
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+				defer cancel()
+				if err := svr.Shutdown(ctx); err != nil {
+					logrus.Errorln(err)
+				}
+				close(serverStopped)
+			}()
+
 			// Serve app
 			logrus.Infoln("Serve shiori in", url)
-			logrus.Fatalln(svr.ListenAndServe())
+			if err := svr.ListenAndServe(); err != http.ErrServerClosed {
+				logrus.Fatalln(err)
+			}
+
+			<-serverStopped
+			if err := db.Close(); err != nil {
+				logrus.Errorln(err)
+			}
 		},
 	}
 
@@ -76,6 +82,55 @@ func NewServeCmd(db dt.Database, dataDir string) *cobra.Command {
 	return rootCmd
 }
 
+// newRouter creates router that serves the web app and API using hdl.
+func newRouter(hdl *webHandler) *httprouter.Router {
+	router := httprouter.New()
+
+	router.GET("/dist/*filepath", hdl.serveFiles)
+
+	router.GET("/", hdl.serveIndexPage)
+	router.GET("/login", hdl.serveLoginPage)
+	router.GET("/bookmark/:id", hdl.serveBookmarkCache)
+	router.GET("/bookmark/:id/archive", hdl.serveBookmarkArchive)
+	router.GET("/bookmark/:id/export", hdl.serveBookmarkExport)
+	router.GET("/archive/:id", hdl.serveArchive)
+	router.GET("/archive/:id/*filepath", hdl.serveArchive)
+	router.GET("/thumb/:id", hdl.serveThumbnailImage)
+	router.GET("/submit", hdl.serveSubmitPage)
+	router.POST("/save", hdl.serveSave)
+	router.GET("/feed.xml", hdl.serveFeed)
+	router.GET("/tags.opml", hdl.serveTagsOPML)
+	router.GET("/healthz", hdl.serveHealthCheck)
+	router.GET("/metrics", hdl.serveMetrics)
+
+	router.POST("/api/login", hdl.apiLogin)
+	router.POST("/api/logout", hdl.requireAPIToken(hdl.apiLogout))
+	router.GET("/api/bookmarks", hdl.requireAPIToken(hdl.apiGetBookmarks))
+	router.GET("/api/tags", hdl.requireAPIToken(hdl.apiGetTags))
+	router.GET("/api/tags/:id/related", hdl.requireAPIToken(hdl.apiGetRelatedTags))
+	router.GET("/api/authors", hdl.requireAPIToken(hdl.apiGetAuthors))
+	router.GET("/api/domains", hdl.requireAPIToken(hdl.apiGetDomains))
+	router.GET("/api/stats", hdl.requireAPIToken(hdl.apiGetStats))
+	router.POST("/api/bookmarks", hdl.requireAPIToken(hdl.apiInsertBookmark))
+	router.POST("/api/bookmarks/fetch", hdl.requireAPIToken(hdl.apiFetchBookmark))
+	router.PUT("/api/cache", hdl.requireAPIToken(hdl.apiUpdateCache))
+	router.PUT("/api/bookmarks", hdl.requireAPIToken(hdl.apiUpdateBookmark))
+	router.PUT("/api/bookmarks/tags", hdl.requireAPIToken(hdl.apiUpdateBookmarkTags))
+	router.DELETE("/api/bookmarks", hdl.requireAPIToken(hdl.apiDeleteBookmark))
+	router.DELETE("/api/bookmarks/:id", hdl.requireAPIToken(hdl.apiDeleteBookmarkByID))
+
+	// Route for panic
+	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, arg interface{}) {
+		if err, ok := arg.(httpError); ok {
+			http.Error(w, err.message, err.code)
+			return
+		}
+		http.Error(w, fmt.Sprint(arg), 500)
+	}
+
+	return router
+}
+
 func checkError(err error) {
 	if err != nil && err != sql.ErrNoRows {
 		panic(err)
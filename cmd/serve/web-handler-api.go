@@ -1,44 +1,40 @@
 package serve
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"math"
 	"net/http"
 	nurl "net/url"
-	"os"
-	fp "path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	valid "github.com/asaskevich/govalidator"
 	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/go-shiori/go-readability"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
-	"golang.org/x/crypto/bcrypt"
+	dt "src.techknowlogick.com/shiori/database"
 	"src.techknowlogick.com/shiori/model"
+	"src.techknowlogick.com/shiori/readability"
 )
 
 // login is handler for POST /api/login
 func (h *webHandler) apiLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Decode request
 	var request model.LoginRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-	checkError(err)
-
-	// Get account data from database
-	account, err := h.db.GetAccount(request.Username)
-	checkError(err)
+	decodeRequest(r, &request)
 
-	// Compare password with database
-	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(request.Password))
-	if err != nil {
-		panic(fmt.Errorf("Username and password don't match"))
+	// Check account and its password in database
+	account, err := h.db.VerifyAccount(r.Context(), request.Username, request.Password)
+	if errors.Is(err, dt.ErrInvalidCredentials) {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
 	}
+	checkError(err)
 
 	// Calculate expiration time
 	nbf := time.Now()
@@ -47,11 +43,16 @@ func (h *webHandler) apiLogin(w http.ResponseWriter, r *http.Request, ps httprou
 		exp = time.Now().Add(7 * 24 * time.Hour)
 	}
 
-	// Create token
+	// Create token, its ID is used to revoke it on logout
+	tokenID, err := uuid.NewV4()
+	checkError(err)
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"nbf": nbf.Unix(),
-		"exp": exp.Unix(),
-		"sub": account.ID,
+		"nbf":   nbf.Unix(),
+		"exp":   exp.Unix(),
+		"jti":   tokenID.String(),
+		"sub":   account.ID,
+		"admin": account.IsAdmin,
 	})
 
 	tokenString, err := token.SignedString(h.jwtKey)
@@ -61,42 +62,218 @@ func (h *webHandler) apiLogin(w http.ResponseWriter, r *http.Request, ps httprou
 	fmt.Fprint(w, tokenString)
 }
 
+// apiLogout is handler for POST /api/logout
+func (h *webHandler) apiLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure the token can't be used anymore
+	h.revokeToken(requestAccount(r))
+
+	// Remove token from cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:   "token",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	fmt.Fprint(w, 1)
+}
+
 // apiGetBookmarks is handler for GET /api/bookmarks
 func (h *webHandler) apiGetBookmarks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	// Get logged in account
+	account := requestAccount(r)
 
 	// Get URL queries
 	keyword := r.URL.Query().Get("keyword")
+	author := r.URL.Query().Get("author")
+	domain := r.URL.Query().Get("domain")
 	strTags := r.URL.Query().Get("tags")
 	tags := strings.Split(strTags, ",")
 	if len(tags) == 1 && tags[0] == "" {
 		tags = []string{}
 	}
 
+	// Limit, offset, prefix and snippet are optional, invalid values are ignored
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	prefix, _ := strconv.ParseBool(r.URL.Query().Get("prefix"))
+	snippet, _ := strconv.ParseBool(r.URL.Query().Get("snippet"))
+
+	// Sort order is optional, but unknown ones are rejected
+	order, err := dt.ParseOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "%v", err))
+	}
+
+	// If nothing changed since the client last fetched the list, it can keep using its copy.
+	// HTTP dates don't have sub-second precision, so ETag is made from the precise time
+	// while Last-Modified is truncated to match them. ETag also covers the number of
+	// bookmarks, which changes when they're purged, and the query, since every query
+	// lists different bookmarks.
+	lastModified, nBookmarks, err := h.db.GetMaxModified(r.Context(), account.ownerFilter())
+	checkError(err)
+
+	var unixNano int64
+	if !lastModified.IsZero() {
+		unixNano = lastModified.UnixNano()
+		lastModified = lastModified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	query := fnv.New64a()
+	io.WriteString(query, r.URL.Query().Encode())
+	etag := fmt.Sprintf(`W/"%d-%d-%x"`, unixNano, nBookmarks, query.Sum64())
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", etag)
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	// Fetch all matching bookmarks
-	bookmarks, err := h.db.SearchBookmarks(true, keyword, tags...)
+	bookmarks, total, err := h.db.SearchBookmarks(r.Context(), dt.SearchOptions{
+		ListOptions: dt.ListOptions{Limit: limit, Offset: offset, OrderBy: order, AccountID: account.ownerFilter()},
+		OrderLatest: true,
+		Keyword:     keyword,
+		Prefix:      prefix,
+		Snippet:     snippet,
+		Tags:        tags,
+		Author:      author,
+		Domain:      domain,
+	})
 	checkError(err)
 
+	// Total count lets the client render page numbers
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
 	err = json.NewEncoder(w).Encode(&bookmarks)
 	checkError(err)
 }
 
+// notModified returns whether the client's cached copy, described by the conditional
+// headers of request, is still the same as the one with etag and lastModified.
+// As in RFC 7232, If-Modified-Since is ignored when If-None-Match is sent.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ims)
+}
+
 // apiGetTags is handler for GET /api/tags
 func (h *webHandler) apiGetTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	account := requestAccount(r)
+
+	// If prefix submitted, only suggest the matching tags for autocomplete
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 10
+		}
+
+		tags, err := h.db.SearchTags(r.Context(), account.ownerFilter(), prefix, limit)
+		checkError(err)
+
+		err = json.NewEncoder(w).Encode(&tags)
+		checkError(err)
+		return
+	}
+
+	// If the bookmarks are being searched, only count the tags within the search result
+	keyword := r.URL.Query().Get("keyword")
+	searchTags := strings.Split(r.URL.Query().Get("tags"), ",")
+	if len(searchTags) == 1 && searchTags[0] == "" {
+		searchTags = []string{}
+	}
+
+	if keyword != "" || len(searchTags) > 0 {
+		tags, err := h.db.GetTagsForSearch(r.Context(), account.ownerFilter(), keyword, searchTags...)
+		checkError(err)
+
+		err = json.NewEncoder(w).Encode(&tags)
+		checkError(err)
+		return
+	}
 
 	// Fetch all tags
-	tags, err := h.db.GetTags()
+	tags, err := h.db.GetTags(r.Context(), account.ownerFilter())
 	checkError(err)
 
 	err = json.NewEncoder(w).Encode(&tags)
 	checkError(err)
 }
 
+// apiGetRelatedTags is handler for GET /api/tags/:id/related
+func (h *webHandler) apiGetRelatedTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get tag ID from URL
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Tag ID is not valid"))
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 10
+	}
+
+	account := requestAccount(r)
+	tags, err := h.db.GetRelatedTags(r.Context(), account.ownerFilter(), id, limit)
+	checkError(err)
+
+	err = json.NewEncoder(w).Encode(&tags)
+	checkError(err)
+}
+
+// apiGetAuthors is handler for GET /api/authors
+func (h *webHandler) apiGetAuthors(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account := requestAccount(r)
+	authors, err := h.db.GetAuthors(r.Context(), account.ownerFilter())
+	checkError(err)
+
+	err = json.NewEncoder(w).Encode(&authors)
+	checkError(err)
+}
+
+// apiGetDomains is handler for GET /api/domains
+func (h *webHandler) apiGetDomains(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	account := requestAccount(r)
+	domains, err := h.db.GetDomains(r.Context(), account.ownerFilter())
+	checkError(err)
+
+	err = json.NewEncoder(w).Encode(&domains)
+	checkError(err)
+}
+
+// apiGetStats is handler for GET /api/stats
+func (h *webHandler) apiGetStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get logged in account
+	account := requestAccount(r)
+
+	stats, err := h.db.GetStats(r.Context(), account.ownerFilter())
+	checkError(err)
+
+	err = json.NewEncoder(w).Encode(&stats)
+	checkError(err)
+}
+
 // apiInsertBookmark is handler for POST /api/bookmark
 func (h *webHandler) apiInsertBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Enable CORS for this endpoint
@@ -104,126 +281,184 @@ func (h *webHandler) apiInsertBookmark(w http.ResponseWriter, r *http.Request, p
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	// Get logged in account
+	account := requestAccount(r)
 
-	// Decode request
-	book := model.Bookmark{}
-	err = json.NewDecoder(r.Body).Decode(&book)
-	checkError(err)
+	// Decode request. Only the fields that describe the page are taken from it,
+	// the others like ID, owner and modified time are set by server.
+	request := model.Bookmark{}
+	decodeRequest(r, &request)
+
+	book := model.Bookmark{
+		URL:      request.URL,
+		Title:    request.Title,
+		Excerpt:  request.Excerpt,
+		Note:     request.Note,
+		Read:     request.Read,
+		Favorite: request.Favorite,
+	}
+	for _, tag := range request.Tags {
+		book.Tags = append(book.Tags, model.Tag{Name: tag.Name})
+	}
 
 	// Make sure URL valid
 	parsedURL, err := nurl.Parse(book.URL)
 	if err != nil || !valid.IsRequestURL(book.URL) {
-		panic(fmt.Errorf("URL is not valid"))
+		panic(newHTTPError(http.StatusBadRequest, "URL is not valid"))
 	}
 
-	// Clear fragment and UTM parameters from URL
-	parsedURL.Fragment = ""
-	clearUTMParams(parsedURL)
+	// Clear fragment from URL. Tracking parameters are removed when it is saved.
+	dt.ClearFragment(parsedURL)
 	book.URL = parsedURL.String()
 
+	// Make sure the URL is not saved yet, ignoring tracking parameters
+	duplicates, err := h.db.FindDuplicateBookmarks(r.Context(), book.URL)
+	checkError(err)
+	for _, duplicate := range duplicates {
+		if account.canAccess(duplicate) {
+			panic(newHTTPError(http.StatusConflict, "URL already saved"))
+		}
+	}
+
 	// Fetch data from internet
-	article, _ := readability.FromURL(parsedURL.String(), 20*time.Second)
+	page, _ := readability.Fetch(book.URL)
+	fillBookmarkFromPage(&book, page)
 
-	book.Author = article.Byline
-	book.MinReadTime = int(math.Floor(float64(article.Length)/(987+188) + 0.5))
-	book.MaxReadTime = int(math.Floor(float64(article.Length)/(987-188) + 0.5))
-	book.Content = article.TextContent
-	book.HTML = article.Content
+	// Save bookmark to database, owned by the logged in account
+	h.insertBookmark(r.Context(), account, &book)
 
-	// If title and excerpt doesnt have submitted value, use from article
-	if book.Title == "" {
-		book.Title = article.Title
-	}
+	// Save bookmark image, so it's still shown when the remote image is gone
+	h.saveThumbnail(r.Context(), &book)
 
-	if book.Excerpt == "" {
-		book.Excerpt = strings.Map(fixUtf, article.Excerpt)
-	}
+	// Return new saved result
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&book)
+	checkError(err)
+}
 
-	// Make sure title is not empty
-	if book.Title == "" {
-		book.Title = book.URL
-	}
+// apiFetchBookmark is handler for POST /api/bookmarks/fetch.
+// Unlike apiInsertBookmark it only needs the URL, and fails if the page can't be fetched.
+func (h *webHandler) apiFetchBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get logged in account
+	account := requestAccount(r)
 
-	// Check if book has content
-	if book.Content != "" {
-		book.HasContent = true
-	}
+	// Decode request
+	request := struct {
+		URL string `json:"url"`
+	}{}
+	decodeRequest(r, &request)
 
-	// Save bookmark image to local disk
-	u2, err := uuid.NewV4()
-	if err != nil {
-		checkError(err)
+	// Make sure URL valid
+	parsedURL, err := nurl.Parse(request.URL)
+	if err != nil || !valid.IsRequestURL(request.URL) {
+		panic(newHTTPError(http.StatusBadRequest, "URL is not valid"))
 	}
-	imgPath := fp.Join(h.dataDir, "thumb", u2.String())
-	err = downloadFile(article.Image, imgPath, 20*time.Second)
-	if err == nil {
-		book.ImageURL = fmt.Sprintf("/thumb/%s", u2)
+
+	// Clear fragment from URL. Tracking parameters are removed when it is saved.
+	dt.ClearFragment(parsedURL)
+	book := model.Bookmark{URL: parsedURL.String()}
+
+	// Make sure the URL is not saved yet, ignoring tracking parameters
+	duplicates, err := h.db.FindDuplicateBookmarks(r.Context(), book.URL)
+	checkError(err)
+	for _, duplicate := range duplicates {
+		if account.canAccess(duplicate) {
+			panic(newHTTPError(http.StatusConflict, "URL already saved"))
+		}
 	}
 
-	// Save bookmark to database
-	err = h.db.InsertBookmark(&book)
+	// Fetch and extract the page
+	page, err := readability.Fetch(book.URL)
 	if err != nil {
-		checkError(err)
+		panic(newHTTPError(http.StatusBadGateway, "Failed to fetch page: %v", err))
 	}
+	fillBookmarkFromPage(&book, page)
+
+	// Save bookmark to database, owned by the logged in account
+	h.insertBookmark(r.Context(), account, &book)
+	h.saveThumbnail(r.Context(), &book)
 
 	// Return new saved result
+	w.WriteHeader(http.StatusCreated)
 	err = json.NewEncoder(w).Encode(&book)
 	checkError(err)
 }
 
-// apiDeleteBookmarks is handler for DELETE /api/bookmark
-func (h *webHandler) apiDeleteBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
+// apiDeleteBookmarkByID is handler for DELETE /api/bookmarks/:id
+func (h *webHandler) apiDeleteBookmarkByID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get logged in account
+	account := requestAccount(r)
+
+	// Get bookmark ID from URL
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Bookmark ID is not valid"))
+	}
+
+	// Make sure the bookmark accessible by the logged in account
+	book, found, err := h.db.GetBookmark(r.Context(), id, false)
+	checkError(err)
+	if !found || !account.canAccess(book) {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
+	}
+
+	// Move bookmark to trash
+	err = h.db.DeleteBookmarks(r.Context(), id)
 	checkError(err)
 
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiDeleteBookmarks is handler for DELETE /api/bookmarks
+func (h *webHandler) apiDeleteBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Get logged in account
+	account := requestAccount(r)
+
 	// Decode request
 	ids := []int{}
-	err = json.NewDecoder(r.Body).Decode(&ids)
-	checkError(err)
+	decodeRequest(r, &ids)
 
-	// Delete bookmarks
-	err = h.db.DeleteBookmarks(ids...)
-	checkError(err)
+	// Empty list means nothing to delete, it must never turn into every bookmark
+	if len(ids) == 0 {
+		panic(newHTTPError(http.StatusBadRequest, "No bookmark IDs submitted"))
+	}
 
-	// Delete thumbnail image from local disk
-	for _, id := range ids {
-		imgPath := fp.Join(h.dataDir, "thumb", fmt.Sprintf("%d", id))
-		os.Remove(imgPath)
+	// Only delete bookmarks that accessible by the logged in account
+	ids, err := h.ownedBookmarkIDs(r, account, ids)
+	checkError(err)
+	if len(ids) == 0 {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
 	}
 
+	// Move bookmarks to trash, their thumbnails are kept so they still can be restored
+	err = h.db.DeleteBookmarks(r.Context(), ids...)
+	checkError(err)
+
 	fmt.Fprint(w, 1)
 }
 
 // apiUpdateBookmark is handler for PUT /api/bookmarks
 func (h *webHandler) apiUpdateBookmark(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	// Get logged in account
+	account := requestAccount(r)
 
 	// Decode request
 	request := model.Bookmark{}
-	err = json.NewDecoder(r.Body).Decode(&request)
-	checkError(err)
+	decodeRequest(r, &request)
 
 	// Validate input
 	if request.Title == "" {
-		panic(fmt.Errorf("Title must not empty"))
+		panic(newHTTPError(http.StatusBadRequest, "Title must not empty"))
 	}
 
 	// Get existing bookmark from database
-	reqID := request.ID
-	bookmarks, err := h.db.GetBookmarks(true, reqID)
+	book, found, err := h.db.GetBookmark(r.Context(), request.ID, true)
 	checkError(err)
-	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("No bookmark with matching index"))
+	if !found || !account.canAccess(book) {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
 	}
 
 	// Set new bookmark data
-	book := bookmarks[0]
 	book.Title = request.Title
 	book.Excerpt = request.Excerpt
 
@@ -247,7 +482,7 @@ func (h *webHandler) apiUpdateBookmark(w http.ResponseWriter, r *http.Request, p
 	}
 
 	// Update database
-	res, err := h.db.UpdateBookmarks(book)
+	res, err := h.db.UpdateBookmarks(r.Context(), book)
 	checkError(err)
 
 	// Return new saved result
@@ -257,9 +492,8 @@ func (h *webHandler) apiUpdateBookmark(w http.ResponseWriter, r *http.Request, p
 
 // apiUpdateBookmarkTags is handler for PUT /api/bookmarks/tags
 func (h *webHandler) apiUpdateBookmarkTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	// Get logged in account
+	account := requestAccount(r)
 
 	// Decode request
 	request := struct {
@@ -267,65 +501,54 @@ func (h *webHandler) apiUpdateBookmarkTags(w http.ResponseWriter, r *http.Reques
 		Tags []model.Tag `json:"tags"`
 	}{}
 
-	err = json.NewDecoder(r.Body).Decode(&request)
-	checkError(err)
+	decodeRequest(r, &request)
 
 	// Validate input
 	if len(request.IDs) == 0 || len(request.Tags) == 0 {
-		panic(fmt.Errorf("IDs and tags must not empty"))
+		panic(newHTTPError(http.StatusBadRequest, "IDs and tags must not empty"))
 	}
 
-	// Get existing bookmark from database
-	bookmarks, err := h.db.GetBookmarks(true, request.IDs...)
+	// Only update bookmarks that accessible by the logged in account
+	var err error
+	request.IDs, err = h.ownedBookmarkIDs(r, account, request.IDs)
 	checkError(err)
-	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("No bookmark with matching index"))
+	if len(request.IDs) == 0 {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
 	}
 
-	// Set new tags
-	for i, book := range bookmarks {
-		for _, newTag := range request.Tags {
-			for _, oldTag := range book.Tags {
-				if newTag.Name == oldTag.Name {
-					newTag.ID = oldTag.ID
-					break
-				}
-			}
-
-			if newTag.ID == 0 {
-				book.Tags = append(book.Tags, newTag)
-			}
-		}
-
-		bookmarks[i] = book
+	// Assign new tags
+	for _, tag := range request.Tags {
+		err = h.db.AddTagToBookmarks(r.Context(), tag.Name, request.IDs)
+		checkError(err)
 	}
 
-	// Update database
-	res, err := h.db.UpdateBookmarks(bookmarks...)
+	// Get updated bookmarks from database
+	bookmarks, err := h.db.GetBookmarks(r.Context(), true, dt.ListOptions{}, request.IDs...)
 	checkError(err)
+	if len(bookmarks) == 0 {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
+	}
 
 	// Return new saved result
-	err = json.NewEncoder(w).Encode(&res)
+	err = json.NewEncoder(w).Encode(&bookmarks)
 	checkError(err)
 }
 
 // apiUpdateCache is handler for PUT /api/cache
 func (h *webHandler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Check token
-	err := h.checkAPIToken(r)
-	checkError(err)
+	// Get logged in account
+	account := requestAccount(r)
 
 	// Decode request
 	ids := []int{}
-	err = json.NewDecoder(r.Body).Decode(&ids)
-	checkError(err)
+	decodeRequest(r, &ids)
 
 	// Prepare wait group and mutex
 	mx := sync.Mutex{}
 	wg := sync.WaitGroup{}
 
 	// Fetch bookmarks from database
-	books, err := h.db.GetBookmarks(false, ids...)
+	books, err := h.db.GetBookmarks(r.Context(), false, dt.ListOptions{AccountID: account.ownerFilter()}, ids...)
 	checkError(err)
 
 	// Download new cache data
@@ -343,37 +566,28 @@ func (h *webHandler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps h
 			}
 
 			// Fetch data from internet
-			article, err := readability.FromURL(parsedURL.String(), 20*time.Second)
+			page, err := readability.Fetch(parsedURL.String())
 			if err != nil {
 				return
 			}
 
-			book.Excerpt = article.Excerpt
-			book.Author = article.Byline
-			book.MinReadTime = int(math.Floor(float64(article.Length)/(987+188) + 0.5))
-			book.MaxReadTime = int(math.Floor(float64(article.Length)/(987-188) + 0.5))
-			book.Content = article.TextContent
-			book.HTML = article.Content
+			book.Excerpt = page.Excerpt
+			book.Author = page.Author
+			book.MinReadTime = page.MinReadTime
+			book.MaxReadTime = page.MaxReadTime
+			book.Content = page.Content
+			book.HTML = page.HTML
+			book.HasContent = page.HasContent
 
 			// Make sure title is not empty
-			if article.Title != "" {
-				book.Title = article.Title
+			if page.Title != "" {
+				book.Title = page.Title
 			}
 
-			// Check if book has content
-			if book.Content != "" {
-				book.HasContent = true
-			}
-
-			// Update bookmark image in local disk
-			u2, err := uuid.NewV4()
-			if err != nil {
-				checkError(err)
-			}
-			imgPath := fp.Join(h.dataDir, "thumb", u2.String())
-			err = downloadFile(article.Image, imgPath, 20*time.Second)
-			if err == nil {
-				book.ImageURL = fmt.Sprintf("/thumb/%s", u2)
+			// Update bookmark image
+			if page.ImageURL != "" {
+				book.ImageURL = page.ImageURL
+				h.saveThumbnail(r.Context(), &book)
 			}
 
 			// Update list of bookmarks
@@ -387,7 +601,7 @@ func (h *webHandler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps h
 	wg.Wait()
 
 	// Update database
-	res, err := h.db.UpdateBookmarks(books...)
+	res, err := h.db.UpdateBookmarks(r.Context(), books...)
 	checkError(err)
 
 	// Return new saved result
@@ -395,51 +609,79 @@ func (h *webHandler) apiUpdateCache(w http.ResponseWriter, r *http.Request, ps h
 	checkError(err)
 }
 
-func downloadFile(url, dstPath string, timeout time.Duration) error {
-	// Fetch data from URL
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Get(url)
-	if err != nil {
-		return err
+// ownedBookmarkIDs filters ids to the bookmarks that accessible by the account.
+// GetBookmarks returns every bookmark when no ID is given, so empty ids returns nil.
+func (h *webHandler) ownedBookmarkIDs(r *http.Request, account tokenAccount, ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
 	}
-	defer resp.Body.Close()
 
-	// Make sure destination directory exist
-	err = os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
+	bookmarks, err := h.db.GetBookmarks(r.Context(), false, dt.ListOptions{AccountID: account.ownerFilter()}, ids...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create destination file
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		return err
+	ownedIDs := make([]int, 0, len(bookmarks))
+	for _, book := range bookmarks {
+		ownedIDs = append(ownedIDs, book.ID)
 	}
-	defer dst.Close()
+	return ownedIDs, nil
+}
 
-	// Write response body to the file
-	_, err = io.Copy(dst, resp.Body)
-	if err != nil {
-		return err
+// insertBookmark saves book as bookmark owned by account. If it can't be saved
+// because of the submitted data, it responds with the matching client error.
+func (h *webHandler) insertBookmark(ctx context.Context, account tokenAccount, book *model.Bookmark) {
+	book.AccountID = account.ID
+	err := h.db.InsertBookmark(ctx, book)
+	switch {
+	case errors.Is(err, dt.ErrBookmarkExists):
+		panic(newHTTPError(http.StatusConflict, "URL already saved"))
+	case errors.Is(err, dt.ErrInvalidBookmark):
+		panic(newHTTPError(http.StatusBadRequest, "%v", err))
 	}
-
-	return nil
+	checkError(err)
 }
 
-func clearUTMParams(url *nurl.URL) {
-	newQuery := nurl.Values{}
-	for key, value := range url.Query() {
-		if !strings.HasPrefix(key, "utm_") {
-			newQuery[key] = value
-		}
+// fillBookmarkFromPage fills the data of bookmark from the data extracted from its page.
+// Title and excerpt that already submitted by user are kept.
+func fillBookmarkFromPage(book *model.Bookmark, page model.Bookmark) {
+	book.Author = page.Author
+	book.MinReadTime = page.MinReadTime
+	book.MaxReadTime = page.MaxReadTime
+	book.Content = page.Content
+	book.HTML = page.HTML
+	book.HasContent = page.HasContent
+	book.ImageURL = page.ImageURL
+
+	// If title and excerpt doesnt have submitted value, use from page
+	if book.Title == "" {
+		book.Title = page.Title
+	}
+
+	if book.Excerpt == "" {
+		book.Excerpt = page.Excerpt
 	}
 
-	url.RawQuery = newQuery.Encode()
+	// Make sure title is not empty
+	if book.Title == "" {
+		book.Title = book.URL
+	}
 }
 
-func fixUtf(r rune) rune {
-	if r == utf8.RuneError {
-		return -1
+// saveThumbnail downloads the image of a saved bookmark to database. If the download failed,
+// e.g. because the image is too big, the bookmark keeps using its remote image.
+func (h *webHandler) saveThumbnail(ctx context.Context, book *model.Bookmark) {
+	if book.ImageURL == "" {
+		return
+	}
+
+	data, mimeType, err := readability.DownloadImage(book.ImageURL, dt.MaxThumbnailSize, 20*time.Second)
+	if err != nil {
+		return
+	}
+
+	err = h.db.SaveThumbnail(ctx, book.ID, data, mimeType)
+	if err == nil {
+		book.HasThumbnail = true
 	}
-	return r
 }
@@ -0,0 +1,301 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestDeleteBookmarksRejectsEmptyList(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	admin, token := createTestAccount(t, hdl, "admin", true)
+	book := createTestBookmark(t, hdl, admin.ID, "https://example.com")
+
+	rec := doRequest(router, "DELETE", "/api/bookmarks", token, strings.NewReader("[]"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	_, found, err := hdl.db.GetBookmark(context.Background(), book.ID, false)
+	if err != nil || !found {
+		t.Errorf("bookmark has been deleted by empty list, err %v", err)
+	}
+}
+
+func TestOwnedBookmarkIDsOfEmptyList(t *testing.T) {
+	hdl, _ := newTestHandler(t)
+	admin, _ := createTestAccount(t, hdl, "admin", true)
+	createTestBookmark(t, hdl, admin.ID, "https://example.com")
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	ids, err := hdl.ownedBookmarkIDs(req, tokenAccount{ID: admin.ID, IsAdmin: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("got ids %v, want none", ids)
+	}
+}
+
+func TestGetDomainsOfAccount(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, _ := createTestAccount(t, hdl, "alice", false)
+	bob, bobToken := createTestAccount(t, hdl, "bob", false)
+
+	createTestBookmark(t, hdl, alice.ID, "https://alice.example.com")
+	createTestBookmark(t, hdl, bob.ID, "https://bob.example.com/first")
+	createTestBookmark(t, hdl, bob.ID, "https://bob.example.com/second")
+
+	rec := doRequest(router, "GET", "/api/domains", bobToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var domains []model.DomainCount
+	if err := json.NewDecoder(rec.Body).Decode(&domains); err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || domains[0].Domain != "bob.example.com" || domains[0].NBookmarks != 2 {
+		t.Errorf("got domains %+v, want only bob.example.com with 2 bookmarks", domains)
+	}
+}
+
+func TestSearchTagsOfAccount(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	bob, _ := createTestAccount(t, hdl, "bob", false)
+
+	createTestBookmark(t, hdl, alice.ID, "https://example.com/alice", "golang")
+	createTestBookmark(t, hdl, bob.ID, "https://example.com/bob", "gossip")
+
+	rec := doRequest(router, "GET", "/api/tags?prefix=go", aliceToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var tags []model.Tag
+	if err := json.NewDecoder(rec.Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "golang" || tags[0].NBookmark != 1 {
+		t.Errorf("got tags %+v, want only golang", tags)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	createTestAccount(t, hdl, "alice", false)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"valid login", `{"username":"alice","password":"password"}`, http.StatusOK},
+		{"wrong password", `{"username":"alice","password":"wrong"}`, http.StatusUnauthorized},
+		{"unknown account", `{"username":"bob","password":"password"}`, http.StatusUnauthorized},
+		{"invalid JSON", `{"username":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "POST", "/api/login", "", strings.NewReader(tt.body))
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestInvalidRequestBody(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	_, token := createTestAccount(t, hdl, "admin", true)
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/api/bookmarks"},
+		{"PUT", "/api/bookmarks"},
+		{"DELETE", "/api/bookmarks"},
+		{"PUT", "/api/bookmarks/tags"},
+		{"PUT", "/api/cache"},
+	}
+
+	for _, req := range requests {
+		rec := doRequest(router, req.method, req.path, token, strings.NewReader("not json"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got status %d, want %d", req.method, req.path, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetBookmarksNotModified(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	admin, token := createTestAccount(t, hdl, "admin", true)
+	createTestBookmark(t, hdl, admin.ID, "https://example.com")
+
+	rec := doRequest(router, "GET", "/api/bookmarks", token, nil)
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("got status %d with ETag %q and Last-Modified %q", rec.Code, etag, lastModified)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		code   int
+	}{
+		{"same ETag", "/api/bookmarks", "If-None-Match", etag, http.StatusNotModified},
+		{"same time", "/api/bookmarks", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"old ETag", "/api/bookmarks", "If-None-Match", `W/"0-0-0"`, http.StatusOK},
+		{"ETag of other query", "/api/bookmarks?keyword=example", "If-None-Match", etag, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(tt.header, tt.value)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+// fixturePage is the article served by the origin server in TestFetchBookmark.
+const fixturePage = `<!DOCTYPE html>
+<html>
+<head><title>Fixture article</title></head>
+<body>
+<article>
+<h1>Fixture article</h1>
+<p>This is the first paragraph of the shiori fixture article, long enough to be kept as content.</p>
+<p>The second paragraph has more words, so the readability extraction treats the article as readable text.</p>
+<p>And the third paragraph ends the article with yet another sentence about nothing in particular.</p>
+</article>
+</body>
+</html>`
+
+func TestFetchBookmark(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(fixturePage))
+	})
+	mux.HandleFunc("/paper.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	})
+	origin := httptest.NewServer(mux)
+	defer origin.Close()
+
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+
+	fetch := func(path string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"url":%q}`, origin.URL+path)
+		return doRequest(router, "POST", "/api/bookmarks/fetch", token, strings.NewReader(body))
+	}
+
+	rec := fetch("/article")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var book model.Bookmark
+	if err := json.NewDecoder(rec.Body).Decode(&book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Fixture article" || !strings.Contains(book.Content, "shiori fixture article") {
+		t.Errorf("got title %q and content %q, want the extracted article", book.Title, book.Content)
+	}
+	if book.AccountID != alice.ID {
+		t.Errorf("bookmark is owned by %d, want %d", book.AccountID, alice.ID)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"saved page", "/article", http.StatusConflict},
+		{"missing page", "/missing", http.StatusBadGateway},
+		{"not HTML", "/paper.pdf", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if rec := fetch(tt.path); rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+
+	bookmarks, err := hdl.db.GetBookmarks(context.Background(), false, dt.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 || bookmarks[1].Title != "paper.pdf" {
+		t.Errorf("got bookmarks %+v, want the article and paper.pdf", bookmarks)
+	}
+}
+
+func TestInsertBookmarkSetsServerFields(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(fixturePage))
+	}))
+	defer origin.Close()
+
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+	bob, _ := createTestAccount(t, hdl, "bob", false)
+
+	body := fmt.Sprintf(`{"id":999,"url":%q,"title":"Mine","accountID":%d,`+
+		`"modified":"2001-01-01T00:00:00Z","deletedAt":"2002-01-01T00:00:00Z","tags":[{"id":42,"name":"go"}]}`,
+		origin.URL+"/article", bob.ID)
+	rec := doRequest(router, "POST", "/api/bookmarks", token, strings.NewReader(body))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	var created model.Bookmark
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 999 || created.Title != "Mine" {
+		t.Errorf("got bookmark %d titled %q, want new ID with submitted title", created.ID, created.Title)
+	}
+
+	book, found, err := hdl.db.GetBookmark(context.Background(), created.ID, true)
+	if err != nil || !found {
+		t.Fatalf("new bookmark is not found, err %v", err)
+	}
+	if book.AccountID != alice.ID {
+		t.Errorf("bookmark is owned by %d, want %d", book.AccountID, alice.ID)
+	}
+	if book.Modified.Year() < 2020 {
+		t.Errorf("got modified time %v, want the time it's saved", book.Modified)
+	}
+	if len(book.Tags) != 1 || book.Tags[0].Name != "go" || book.Tags[0].ID == 42 {
+		t.Errorf("got tags %+v, want new tag go", book.Tags)
+	}
+}
+
+func TestInsertInvalidBookmark(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	_, token := createTestAccount(t, hdl, "alice", false)
+
+	// Accepted as request URL, but it can't be saved as bookmark
+	body := `{"url":"ftp://example.com/file.txt","title":"File"}`
+	rec := doRequest(router, "POST", "/api/bookmarks", token, strings.NewReader(body))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+}
@@ -1,19 +1,34 @@
 package serve
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/http"
 	nurl "net/url"
 	"os"
+	"path"
 	fp "path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	valid "github.com/asaskevich/govalidator"
 	"github.com/gobuffalo/packr/v2"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+	"src.techknowlogick.com/shiori/readability"
 )
 
 // serveFiles serve files
@@ -25,7 +40,7 @@ func (h *webHandler) serveFiles(w http.ResponseWriter, r *http.Request, ps httpr
 // serveIndexPage is handler for GET /
 func (h *webHandler) serveIndexPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Check token
-	err := h.checkToken(r)
+	_, err := h.checkToken(r)
 	if err != nil {
 		redirectPage(w, r, "/login")
 		return
@@ -41,10 +56,78 @@ func (h *webHandler) serveSubmitPage(w http.ResponseWriter, r *http.Request, ps
 	checkError(err)
 }
 
+// serveSave is handler for POST /save
+// It accepts form data, so it can be used by bookmarklet which simply submits a form with the
+// current page. After the bookmark is saved, browser is redirected to the saved bookmark.
+func (h *webHandler) serveSave(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkToken(r)
+	if err != nil {
+		account, err = h.checkAPIToken(r)
+	}
+	if err != nil {
+		redirectPage(w, r, "/login")
+		return
+	}
+
+	// Parse form
+	err = r.ParseForm()
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Form is not valid: %v", err))
+	}
+
+	// Make sure URL valid
+	rawURL := strings.TrimSpace(r.PostForm.Get("url"))
+	parsedURL, err := nurl.Parse(rawURL)
+	if err != nil || !valid.IsRequestURL(rawURL) {
+		panic(newHTTPError(http.StatusBadRequest, "URL is not valid"))
+	}
+
+	// Clear fragment from URL. Tracking parameters are removed when it is saved.
+	dt.ClearFragment(parsedURL)
+	book := model.Bookmark{
+		URL:   parsedURL.String(),
+		Title: strings.TrimSpace(r.PostForm.Get("title")),
+	}
+
+	// If the URL already saved, just show the saved bookmark
+	duplicates, err := h.db.FindDuplicateBookmarks(r.Context(), book.URL)
+	checkError(err)
+	for _, duplicate := range duplicates {
+		if account.canAccess(duplicate) {
+			http.Redirect(w, r, fmt.Sprintf("/bookmark/%d", duplicate.ID), http.StatusFound)
+			return
+		}
+	}
+
+	// Tags are submitted as comma separated list
+	for _, tag := range strings.Split(r.PostForm.Get("tags"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			book.Tags = append(book.Tags, model.Tag{Name: tag})
+		}
+	}
+
+	// Fetch data from internet. Like in API, the bookmark is still saved if it fails.
+	page, _ := readability.Fetch(book.URL)
+	fillBookmarkFromPage(&book, page)
+
+	// Save bookmark to database, owned by the logged in account
+	book.AccountID = account.ID
+	err = h.db.InsertBookmark(r.Context(), &book)
+	if errors.Is(err, dt.ErrBookmarkExists) {
+		panic(newHTTPError(http.StatusConflict, "URL already saved"))
+	}
+	checkError(err)
+	h.saveThumbnail(r.Context(), &book)
+
+	http.Redirect(w, r, fmt.Sprintf("/bookmark/%d", book.ID), http.StatusFound)
+}
+
 // serveLoginPage is handler for GET /login
 func (h *webHandler) serveLoginPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Check token
-	err := h.checkToken(r)
+	_, err := h.checkToken(r)
 	if err == nil {
 		redirectPage(w, r, "/")
 		return
@@ -56,17 +139,27 @@ func (h *webHandler) serveLoginPage(w http.ResponseWriter, r *http.Request, ps h
 
 // serveBookmarkCache is handler for GET /bookmark/:id
 func (h *webHandler) serveBookmarkCache(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkToken(r)
+	if err != nil {
+		account, err = h.checkAPIToken(r)
+	}
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
 	// Get bookmark ID from URL
-	strID := ps.ByName("id")
-	id, err := strconv.Atoi(strID)
-	checkError(err)
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Bookmark ID is not valid"))
+	}
 
 	// Get bookmarks in database
-	bookmarks, err := h.db.GetBookmarks(true, id)
+	bookmark, found, err := h.db.GetBookmark(r.Context(), id, true)
 	checkError(err)
 
-	if len(bookmarks) == 0 {
-		panic(fmt.Errorf("No bookmark with matching index"))
+	if !found || !account.canAccess(bookmark) {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
 	}
 
 	// Create template
@@ -87,7 +180,7 @@ func (h *webHandler) serveBookmarkCache(w http.ResponseWriter, r *http.Request,
 	tplCache, err := createTemplate("cache.html", funcMap)
 	checkError(err)
 
-	bt, err := json.Marshal(&bookmarks[0])
+	bt, err := json.Marshal(&bookmark)
 	checkError(err)
 
 	// Execute template
@@ -96,11 +189,275 @@ func (h *webHandler) serveBookmarkCache(w http.ResponseWriter, r *http.Request,
 	checkError(err)
 }
 
+// exportTemplate is the page of a bookmark exported by serveBookmarkExport. It's kept here
+// instead of in dist, since the exported page must not depend on any other files.
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 800px; margin: 0 auto; padding: 20px; font-family: serif; line-height: 1.6; color: #232323; }
+header { border-bottom: 1px solid #e5e5e5; margin-bottom: 20px; }
+header p { font-family: sans-serif; font-size: 0.9em; color: #737373; }
+img, video { max-width: 100%; height: auto; }
+pre { overflow: auto; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<p>{{if .Author}}{{.Author}} &middot; {{end}}<a href="{{.URL}}">{{.URL}}</a></p>
+{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="">{{end}}
+</header>
+<main>{{.Content}}</main>
+</body>
+</html>
+`))
+
+// serveBookmarkExport is handler for GET /bookmark/:id/export
+// It serves the content of bookmark as self-contained HTML file, which can be shared as is.
+func (h *webHandler) serveBookmarkExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkToken(r)
+	if err != nil {
+		account, err = h.checkAPIToken(r)
+	}
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
+	// Get bookmark ID from URL
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Bookmark ID is not valid"))
+	}
+
+	// Get bookmark with its content
+	bookmark, found, err := h.db.GetBookmark(r.Context(), id, true)
+	checkError(err)
+	if !found || !account.canAccess(bookmark) {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
+	}
+
+	// Page without readable HTML falls back to its text content
+	content := template.HTML(bookmark.HTML)
+	if bookmark.HTML == "" {
+		content = template.HTML("<p>" + strings.Replace(template.HTMLEscapeString(bookmark.Content), "\n", "<br>", -1) + "</p>")
+	}
+
+	// Inline the thumbnail, so the file still shows it when opened offline
+	var thumbnail template.URL
+	if bookmark.HasThumbnail {
+		data, mimeType, err := h.db.GetThumbnail(r.Context(), id)
+		if err == nil {
+			thumbnail = template.URL("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+		}
+	} else if strings.HasPrefix(bookmark.ImageURL, "http://") || strings.HasPrefix(bookmark.ImageURL, "https://") {
+		thumbnail = template.URL(bookmark.ImageURL)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("bookmark-%d.html", bookmark.ID),
+	}))
+	err = exportTemplate.Execute(w, struct {
+		Title     string
+		Author    string
+		URL       string
+		Thumbnail template.URL
+		Content   template.HTML
+	}{bookmark.Title, bookmark.Author, bookmark.URL, thumbnail, content})
+	checkError(err)
+}
+
+// serveBookmarkArchive is handler for GET /bookmark/:id/archive
+func (h *webHandler) serveBookmarkArchive(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkToken(r)
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
+	// Get bookmark ID from URL
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Bookmark ID is not valid"))
+	}
+
+	h.writeArchive(w, r, account, id, "")
+}
+
+// serveArchive is handler for GET /archive/:id and GET /archive/:id/*filepath
+// Unlike serveBookmarkArchive it's meant for other apps, so it accepts API token like feeds do.
+// The paths below the archive serve its embedded assets.
+func (h *webHandler) serveArchive(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkFeedToken(r)
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
+	// Get bookmark ID from URL
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Bookmark ID is not valid"))
+	}
+
+	h.writeArchive(w, r, account, id, ps.ByName("filepath"))
+}
+
+// writeArchive writes the archived copy of bookmark with matching id to response.
+// If assetPath is not empty, the asset embedded at that path is written instead.
+// Client may cache it, and revalidate using the modified time of the bookmark.
+func (h *webHandler) writeArchive(w http.ResponseWriter, r *http.Request, account tokenAccount, id int, assetPath string) {
+	// Make sure the bookmark accessible by the logged in account
+	bookmark, found, err := h.db.GetBookmark(r.Context(), id, false)
+	checkError(err)
+	if !found || !account.canAccess(bookmark) {
+		panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
+	}
+
+	if !bookmark.HasArchive {
+		panic(newHTTPError(http.StatusNotFound, "Bookmark doesn't have archive"))
+	}
+
+	// Get archive from database
+	data, mimeType, err := h.db.GetArchive(r.Context(), id)
+	checkError(err)
+
+	// Serve archive. ServeContent handles the conditional and range requests.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	setSandboxHeaders(w)
+	if assetPath == "" {
+		setArchiveContentType(w, mimeType)
+		http.ServeContent(w, r, "", bookmark.Modified, bytes.NewReader(data))
+		return
+	}
+
+	// Assets of ZIP archive don't have stored type, so it's guessed from their name
+	name, asset, err := archiveAsset(data, mimeType, assetPath)
+	if err != nil {
+		panic(newHTTPError(http.StatusNotFound, "%v", err))
+	}
+	if name != "" {
+		mimeType = mime.TypeByExtension(path.Ext(name))
+	}
+	setArchiveContentType(w, mimeType)
+	http.ServeContent(w, r, name, bookmark.Modified, bytes.NewReader(asset))
+}
+
+// inlineArchiveTypes are the media types of archived content that may be shown in browser.
+// Other types, e.g. SVG and XHTML documents that can run scripts, are sent as download.
+var inlineArchiveTypes = map[string]bool{
+	"text/html":       true,
+	"text/plain":      true,
+	"text/css":        true,
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+// setSandboxHeaders stops browsers from running content saved from other sites as shiori,
+// since it's served from the same origin. Its type is never sniffed, and documents are shown
+// in sandbox where scripts are disabled and the cookies of shiori are not accessible.
+func setSandboxHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// setArchiveContentType sets the content type of archived content, and makes it a download
+// unless its type is in inlineArchiveTypes.
+func setArchiveContentType(w http.ResponseWriter, contentType string) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !inlineArchiveTypes[mediaType] {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+}
+
+// archiveAsset returns name and content of the asset at assetPath within archive data.
+// Only ZIP archives embed assets, and their root is index.html. Any other archive is a
+// single document, so it's the only asset at root and it's returned without name.
+func archiveAsset(data []byte, mimeType, assetPath string) (string, []byte, error) {
+	name := strings.TrimPrefix(path.Clean("/"+assetPath), "/")
+	if mimeType != "application/zip" && mimeType != "application/x-zip-compressed" {
+		if name != "" {
+			return "", nil, fmt.Errorf("Archive doesn't have embedded assets")
+		}
+		return "", data, nil
+	}
+
+	if name == "" {
+		name = "index.html"
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", nil, fmt.Errorf("Archive is not valid: %v", err)
+	}
+
+	for _, file := range zipReader.File {
+		if file.Name != name {
+			continue
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		defer src.Close()
+
+		content, err := ioutil.ReadAll(src)
+		return name, content, err
+	}
+
+	return "", nil, fmt.Errorf("Archive doesn't have %s", name)
+}
+
 // serveThumbnailImage is handler for GET /thumb/:id
 func (h *webHandler) serveThumbnailImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkToken(r)
+	if err != nil {
+		account, err = h.checkAPIToken(r)
+	}
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
 	// Get bookmark ID from URL
 	id := ps.ByName("id")
 
+	// Thumbnail of bookmark is saved in database, while the older ones
+	// are saved in local disk using random name.
+	if bookmarkID, err := strconv.Atoi(id); err == nil {
+		bookmark, found, err := h.db.GetBookmark(r.Context(), bookmarkID, false)
+		checkError(err)
+		if !found || !account.canAccess(bookmark) {
+			panic(newHTTPError(http.StatusNotFound, "No bookmark with matching index"))
+		}
+
+		data, mimeType, err := h.db.GetThumbnail(r.Context(), bookmarkID)
+		if err != nil {
+			panic(newHTTPError(http.StatusNotFound, "%v", err))
+		}
+
+		// Thumbnails saved before only images were accepted may be any image, e.g. SVG
+		setSandboxHeaders(w)
+		w.Header().Set("Content-Type", mimeType)
+		_, err = w.Write(data)
+		checkError(err)
+		return
+	}
+
 	// Open image
 	imgPath := fp.Join(h.dataDir, "thumb", id)
 	img, err := os.Open(imgPath)
@@ -113,6 +470,7 @@ func (h *webHandler) serveThumbnailImage(w http.ResponseWriter, r *http.Request,
 	checkError(err)
 
 	mimeType := http.DetectContentType(buffer)
+	setSandboxHeaders(w)
 	w.Header().Set("Content-Type", mimeType)
 
 	// Serve image
@@ -141,3 +499,218 @@ func serveFile(w http.ResponseWriter, path string) error {
 	w.Write(src)
 	return nil
 }
+
+// rssFeed is the RSS 2.0 document served by serveFeed
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	GUID        string `xml:"guid"`
+}
+
+// checkFeedToken checks the token of request for feeds and returns the logged in account.
+// Since most feed readers can't send custom header, an API token can be submitted in query.
+func (h *webHandler) checkFeedToken(r *http.Request) (tokenAccount, error) {
+	account, err := h.checkAPIToken(r)
+	if token := r.URL.Query().Get("token"); err != nil && token != "" {
+		var tokenOwner model.Account
+		tokenOwner, err = h.db.ResolveAPIToken(r.Context(), token)
+		account = tokenAccount{ID: tokenOwner.ID, IsAdmin: tokenOwner.IsAdmin}
+	}
+	return account, err
+}
+
+// requestBaseURL returns the scheme and host used by client to access the server.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// serveFeed is handler for GET /feed.xml
+func (h *webHandler) serveFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	account, err := h.checkFeedToken(r)
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
+	// Limit is optional, but never too big
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	opts := dt.SearchOptions{
+		ListOptions: dt.ListOptions{Limit: limit, AccountID: account.ownerFilter()},
+		OrderLatest: true,
+	}
+	title := "shiori"
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		opts.Tags = []string{tag}
+		title = "shiori - " + tag
+	}
+
+	bookmarks, _, err := h.db.SearchBookmarks(r.Context(), opts)
+	checkError(err)
+
+	// Create feed
+	baseURL := requestBaseURL(r)
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        baseURL + "/",
+			Description: "Recently saved bookmarks",
+			Items:       make([]rssItem, 0, len(bookmarks)),
+		},
+	}
+
+	for _, book := range bookmarks {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       book.Title,
+			Link:        book.URL,
+			Description: book.Excerpt,
+			PubDate:     book.Modified.Format(time.RFC1123Z),
+			GUID:        fmt.Sprintf("%s/bookmark/%d", baseURL, book.ID),
+		})
+	}
+
+	// Serve feed
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	_, err = io.WriteString(w, xml.Header)
+	checkError(err)
+
+	err = xml.NewEncoder(w).Encode(&feed)
+	checkError(err)
+}
+
+// opmlDocument is the OPML document served by serveTagsOPML
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Feeds   []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Type   string `xml:"type,attr"`
+	Text   string `xml:"text,attr"`
+	Title  string `xml:"title,attr"`
+	XMLURL string `xml:"xmlUrl,attr"`
+}
+
+// serveTagsOPML is handler for GET /tags.opml
+// It lists feed of every tag, so all of them can be subscribed at once.
+func (h *webHandler) serveTagsOPML(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Make sure session still valid
+	account, err := h.checkFeedToken(r)
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+
+	tags, err := h.db.GetTags(r.Context(), account.ownerFilter())
+	checkError(err)
+
+	// Feed reader needs the same token to read the feeds
+	baseURL := requestBaseURL(r)
+	doc := opmlDocument{
+		Version: "2.0",
+		Title:   "shiori tags",
+		Feeds:   make([]opmlOutline, 0, len(tags)),
+	}
+
+	for _, tag := range tags {
+		query := nurl.Values{"tag": {tag.Name}}
+		if token := r.URL.Query().Get("token"); token != "" {
+			query.Set("token", token)
+		}
+
+		doc.Feeds = append(doc.Feeds, opmlOutline{
+			Type:   "rss",
+			Text:   tag.Name,
+			Title:  tag.Name,
+			XMLURL: baseURL + "/feed.xml?" + query.Encode(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	_, err = io.WriteString(w, xml.Header)
+	checkError(err)
+
+	err = xml.NewEncoder(w).Encode(&doc)
+	checkError(err)
+}
+
+// serveHealthCheck is handler for GET /healthz
+// It responds with 503 if database is not reachable, so container orchestrator can restart
+// shiori. The error is only logged, since it's not meant for anonymous callers. Admin may use
+// ?verbose=1 to also see the statistics of database connection pool.
+func (h *webHandler) serveHealthCheck(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	response := map[string]interface{}{"status": "ok"}
+	status := http.StatusOK
+	if err := h.db.Ping(ctx); err != nil {
+		logrus.Errorln("Health check failed:", err)
+		response["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose && h.isAdminRequest(r) {
+		stats := h.db.Stats()
+		response["database"] = map[string]int{
+			"maxOpenConnections": stats.MaxOpenConnections,
+			"openConnections":    stats.OpenConnections,
+			"inUse":              stats.InUse,
+			"idle":               stats.Idle,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(&response)
+	checkError(err)
+}
+
+// serveMetrics is handler for GET /metrics
+// It serves the metrics of database in Prometheus text format. Only admin may read them,
+// so Prometheus must send API token of an admin as bearer token.
+func (h *webHandler) serveMetrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Check token
+	account, err := h.checkAPIToken(r)
+	if err != nil {
+		panic(newHTTPError(http.StatusUnauthorized, "%v", err))
+	}
+	if !account.IsAdmin {
+		panic(newHTTPError(http.StatusForbidden, "Metrics are only available to admin"))
+	}
+
+	metrics, ok := h.db.(*dt.MetricsDatabase)
+	if !ok {
+		panic(newHTTPError(http.StatusNotFound, "Metrics are not recorded"))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err = metrics.WriteMetrics(w)
+	checkError(err)
+}
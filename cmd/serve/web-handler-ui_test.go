@@ -0,0 +1,504 @@
+package serve
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestTagsOPMLOnlyListsTagsOfAccount(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	bob, _ := createTestAccount(t, hdl, "bob", false)
+
+	createTestBookmark(t, hdl, alice.ID, "https://example.com/alice", "news")
+	createTestBookmark(t, hdl, bob.ID, "https://example.com/bob", "private")
+
+	rec := doRequest(router, "GET", "/tags.opml?token="+aliceToken, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `text="news"`) {
+		t.Errorf("feed of own tag is missing:\n%s", body)
+	}
+	if strings.Contains(body, "private") {
+		t.Errorf("feed of tag used by other account is listed:\n%s", body)
+	}
+}
+
+// zipArchive returns ZIP archive with the files, mapped by their names.
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	zipWriter := zip.NewWriter(&buf)
+	for name, content := range files {
+		dst, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeArchive(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	_, bobToken := createTestAccount(t, hdl, "bob", false)
+	ctx := context.Background()
+
+	pdf := createTestBookmark(t, hdl, alice.ID, "https://example.com/paper.pdf")
+	if err := hdl.db.SaveArchive(ctx, pdf.ID, []byte("%PDF-1.4"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	site := createTestBookmark(t, hdl, alice.ID, "https://example.com/site.zip")
+	data := zipArchive(t, map[string]string{
+		"index.html":    "<html><body>index</body></html>",
+		"css/style.css": "body { color: black; }",
+	})
+	if err := hdl.db.SaveArchive(ctx, site.ID, data, "application/zip"); err != nil {
+		t.Fatal(err)
+	}
+
+	unarchived := createTestBookmark(t, hdl, alice.ID, "https://example.com/unarchived")
+
+	pdfPath := "/archive/" + strconv.Itoa(pdf.ID)
+	sitePath := "/archive/" + strconv.Itoa(site.ID)
+
+	tests := []struct {
+		name        string
+		path        string
+		token       string
+		code        int
+		contentType string
+	}{
+		{"snapshot", pdfPath, aliceToken, http.StatusOK, "application/pdf"},
+		{"root of snapshot", pdfPath + "/", aliceToken, http.StatusOK, "application/pdf"},
+		{"asset of snapshot", pdfPath + "/style.css", aliceToken, http.StatusNotFound, ""},
+		{"index of zip", sitePath + "/", aliceToken, http.StatusOK, "text/html; charset=utf-8"},
+		{"stylesheet of zip", sitePath + "/css/style.css", aliceToken, http.StatusOK, "text/css; charset=utf-8"},
+		{"missing asset of zip", sitePath + "/css/missing.css", aliceToken, http.StatusNotFound, ""},
+		{"bookmark without archive", "/archive/" + strconv.Itoa(unarchived.ID), aliceToken, http.StatusNotFound, ""},
+		{"archive of other account", pdfPath, bobToken, http.StatusNotFound, ""},
+		{"asset of other account", sitePath + "/css/style.css", bobToken, http.StatusNotFound, ""},
+		{"without login", pdfPath, "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", tt.path, tt.token, nil)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); tt.contentType != "" && got != tt.contentType {
+			t.Errorf("%s: got content type %q, want %q", tt.name, got, tt.contentType)
+		}
+	}
+}
+
+func TestServeBookmarkArchive(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, _ := createTestAccount(t, hdl, "alice", false)
+
+	book := createTestBookmark(t, hdl, alice.ID, "https://example.com/paper.pdf")
+	if err := hdl.db.SaveArchive(context.Background(), book.ID, []byte("%PDF-1.4"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doRequest(router, "POST", "/api/login", "", strings.NewReader(`{"username":"alice","password":"password"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	session := rec.Body.String()
+
+	// The web interface opens archive with the session in cookie
+	tests := []struct {
+		name   string
+		id     string
+		cookie string
+		code   int
+	}{
+		{"session", strconv.Itoa(book.ID), session, http.StatusOK},
+		{"without session", strconv.Itoa(book.ID), "", http.StatusUnauthorized},
+		{"invalid session", strconv.Itoa(book.ID), "invalid", http.StatusUnauthorized},
+		{"invalid ID", "paper", session, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/bookmark/"+tt.id+"/archive", nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: tt.cookie})
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestServeArchiveInSandbox(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+	ctx := context.Background()
+
+	svg := createTestBookmark(t, hdl, alice.ID, "https://example.com/logo.svg")
+	image := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(document.cookie)</script></svg>`
+	if err := hdl.db.SaveArchive(ctx, svg.ID, []byte(image), "image/svg+xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	site := createTestBookmark(t, hdl, alice.ID, "https://example.com/site.zip")
+	data := zipArchive(t, map[string]string{
+		"index.html": `<html><body><img src="logo.svg"><script src="app.js"></script></body></html>`,
+		"logo.svg":   image,
+		"app.js":     "alert(document.cookie)",
+		"page.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><script>alert(1)</script></html>`,
+	})
+	if err := hdl.db.SaveArchive(ctx, site.ID, data, "application/zip"); err != nil {
+		t.Fatal(err)
+	}
+
+	sitePath := "/archive/" + strconv.Itoa(site.ID)
+	tests := []struct {
+		path     string
+		download bool
+	}{
+		{"/archive/" + strconv.Itoa(svg.ID), true},
+		{sitePath, false},
+		{sitePath + "/logo.svg", true},
+		{sitePath + "/app.js", true},
+		{sitePath + "/page.xhtml", true},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", tt.path, token, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, http.StatusOK)
+			continue
+		}
+
+		header := rec.Header()
+		if header.Get("Content-Security-Policy") != "sandbox" || header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: got CSP %q and X-Content-Type-Options %q, want sandbox and nosniff", tt.path,
+				header.Get("Content-Security-Policy"), header.Get("X-Content-Type-Options"))
+		}
+		if download := strings.HasPrefix(header.Get("Content-Disposition"), "attachment"); download != tt.download {
+			t.Errorf("%s: got Content-Disposition %q, want download %v", tt.path, header.Get("Content-Disposition"), tt.download)
+		}
+	}
+}
+
+func TestServeThumbnailInSandbox(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+
+	// Saved before thumbnails were limited to bitmap images
+	book := createTestBookmark(t, hdl, alice.ID, "https://example.com/logo")
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(document.cookie)"/>`)
+	if err := hdl.db.SaveThumbnail(context.Background(), book.ID, svg, "image/svg+xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doRequest(router, "GET", "/thumb/"+strconv.Itoa(book.ID), token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "sandbox" {
+		t.Errorf("got Content-Security-Policy %q, want sandbox", csp)
+	}
+	if nosniff := rec.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("got X-Content-Type-Options %q, want nosniff", nosniff)
+	}
+}
+
+func TestServeFeed(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	bob, _ := createTestAccount(t, hdl, "bob", false)
+
+	first := createTestBookmark(t, hdl, alice.ID, "https://example.com/first", "news")
+	second := createTestBookmark(t, hdl, alice.ID, "https://example.com/second", "tech")
+	createTestBookmark(t, hdl, bob.ID, "https://example.com/bob", "news")
+
+	// Bookmarks are saved in the same second, so make the first one older
+	past := time.Now().Add(-time.Hour).Format("2006-01-02 15:04:05")
+	if _, err := hdl.db.(*dt.XormDatabase).Exec("UPDATE bookmark SET created = ? WHERE id = ?", past, first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := doRequest(router, "GET", "/feed.xml", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	tests := []struct {
+		query string
+		title string
+		links []string
+	}{
+		{"", "shiori", []string{second.URL, first.URL}},
+		{"&tag=news", "shiori - news", []string{first.URL}},
+		{"&limit=1", "shiori", []string{second.URL}},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", "/feed.xml?token="+aliceToken+tt.query, "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: got status %d, want %d", tt.query, rec.Code, http.StatusOK)
+		}
+
+		var feed rssFeed
+		if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if feed.Version != "2.0" || feed.Channel.Title != tt.title {
+			t.Errorf("%q: got RSS %s titled %q, want 2.0 titled %q", tt.query, feed.Version, feed.Channel.Title, tt.title)
+		}
+
+		var links []string
+		for _, item := range feed.Channel.Items {
+			links = append(links, item.Link)
+			if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+				t.Errorf("%q: pubDate of %s: %v", tt.query, item.Link, err)
+			}
+		}
+		if strings.Join(links, " ") != strings.Join(tt.links, " ") {
+			t.Errorf("%q: got items %v, want %v", tt.query, links, tt.links)
+		}
+	}
+}
+
+func TestServeSave(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/article" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(fixturePage))
+	}))
+	defer origin.Close()
+
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+
+	// The bookmarklet submits a plain form, like browser does
+	save := func(token string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	article := url.Values{"url": {origin.URL + "/article#comments"}, "tags": {"news, , Tech"}}
+	if rec := save("", article); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/login" {
+		t.Errorf("without login got status %d to %q, want redirect to /login", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec := save(token, article)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	id, err := strconv.Atoi(strings.TrimPrefix(location, "/bookmark/"))
+	if err != nil {
+		t.Fatalf("got redirect to %q, want the saved bookmark", location)
+	}
+
+	book, _, err := hdl.db.GetBookmark(context.Background(), id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.URL != origin.URL+"/article" || book.Title != "Fixture article" || book.AccountID != alice.ID {
+		t.Errorf("got %s %q of account %d, want the extracted article of alice", book.URL, book.Title, book.AccountID)
+	}
+	var tags []string
+	for _, tag := range book.Tags {
+		tags = append(tags, tag.Name)
+	}
+	if strings.Join(tags, ",") != "news,tech" {
+		t.Errorf("got tags %v, want news and tech", tags)
+	}
+
+	// Saving it again shows the saved bookmark
+	if rec := save(token, article); rec.Code != http.StatusFound || rec.Header().Get("Location") != location {
+		t.Errorf("saved again got status %d to %q, want redirect to %q", rec.Code, rec.Header().Get("Location"), location)
+	}
+
+	// A page that fails to fetch is still saved, with the submitted title
+	rec = save(token, url.Values{"url": {origin.URL + "/missing"}, "title": {"Missing page"}})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("missing page got status %d, want %d", rec.Code, http.StatusFound)
+	}
+	if id := hdl.db.GetBookmarkID(context.Background(), origin.URL+"/missing"); id == 0 {
+		t.Error("missing page is not saved")
+	}
+
+	if rec := save(token, url.Values{"url": {"not a url"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid URL got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestServeHealthCheck(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	_, adminToken := createTestAccount(t, hdl, "admin", true)
+	_, aliceToken := createTestAccount(t, hdl, "alice", false)
+
+	check := func(path, token string) (int, map[string]interface{}) {
+		rec := doRequest(router, "GET", path, token, nil)
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code, body
+	}
+
+	code, body := check("/healthz", "")
+	if code != http.StatusOK || body["status"] != "ok" || body["database"] != nil {
+		t.Errorf("got status %d with %v, want ok without pool stats", code, body)
+	}
+
+	code, body = check("/healthz?verbose=1", adminToken)
+	if stats, ok := body["database"].(map[string]interface{}); code != http.StatusOK || !ok || stats["maxOpenConnections"] == nil {
+		t.Errorf("verbose got status %d with %v, want pool stats", code, body)
+	}
+
+	// Pool stats are only shown to admin
+	for _, token := range []string{"", aliceToken} {
+		if code, body = check("/healthz?verbose=1", token); code != http.StatusOK || body["database"] != nil {
+			t.Errorf("verbose without admin got status %d with %v, want no pool stats", code, body)
+		}
+	}
+
+	// Once the database is gone, orchestrator must know it, but not why
+	if err := hdl.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	code, body = check("/healthz", "")
+	if code != http.StatusServiceUnavailable || body["status"] != "unavailable" || body["error"] != nil {
+		t.Errorf("closed database got status %d with %v, want %d without error", code, body, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServeMetricsToAdmin(t *testing.T) {
+	hdl, _ := newTestHandler(t)
+	_, adminToken := createTestAccount(t, hdl, "admin", true)
+	_, aliceToken := createTestAccount(t, hdl, "alice", false)
+
+	// Metrics are only recorded when database is wrapped, like it's done by main
+	hdl.db = dt.NewMetricsDatabase(hdl.db)
+	router := newRouter(hdl)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"without login", "", http.StatusUnauthorized},
+		{"invalid token", "invalid", http.StatusUnauthorized},
+		{"not admin", aliceToken, http.StatusForbidden},
+		{"admin", adminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", "/metrics", tt.token, nil)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+		if tt.code == http.StatusOK && !strings.Contains(rec.Body.String(), "ResolveAPIToken") {
+			t.Errorf("%s: got metrics %q, want the calls to check the token", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestServeBookmarkExport(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, token := createTestAccount(t, hdl, "alice", false)
+	ctx := context.Background()
+
+	readable := model.Bookmark{
+		URL:       "https://example.com/article",
+		Title:     "Tom & Jerry",
+		Author:    "Jane Doe",
+		Content:   "Readable content",
+		HTML:      "<p>Readable <em>content</em></p>",
+		AccountID: alice.ID,
+	}
+	if err := hdl.db.InsertBookmark(ctx, &readable); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdl.db.SaveThumbnail(ctx, readable.ID, []byte("thumbnail"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	textOnly := model.Bookmark{
+		URL:       "https://example.com/text",
+		Title:     "Text only",
+		Content:   "First line\n<script>second line</script>",
+		AccountID: alice.ID,
+	}
+	if err := hdl.db.InsertBookmark(ctx, &textOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		book model.Bookmark
+		want []string
+	}{
+		{readable, []string{
+			"<title>Tom &amp; Jerry</title>",
+			`Jane Doe &middot; <a href="https://example.com/article">https://example.com/article</a>`,
+			`<img src="data:image/png;base64,`,
+			"<main><p>Readable <em>content</em></p></main>",
+		}},
+		{textOnly, []string{
+			`<a href="https://example.com/text">https://example.com/text</a>`,
+			"<main><p>First line<br>&lt;script&gt;second line&lt;/script&gt;</p></main>",
+		}},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", "/bookmark/"+strconv.Itoa(tt.book.ID)+"/export", token, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", tt.book.URL, rec.Code, http.StatusOK)
+		}
+
+		want := fmt.Sprintf("attachment; filename=bookmark-%d.html", tt.book.ID)
+		if got := rec.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: got Content-Disposition %q, want %q", tt.book.URL, got, want)
+		}
+
+		body := rec.Body.String()
+		for _, part := range tt.want {
+			if !strings.Contains(body, part) {
+				t.Errorf("%s: %q is missing from\n%s", tt.book.URL, part, body)
+			}
+		}
+	}
+}
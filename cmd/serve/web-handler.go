@@ -1,15 +1,23 @@
 package serve
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"github.com/gobuffalo/packr/v2"
+	"github.com/julienschmidt/httprouter"
 	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
 )
 
 // webHandler is handler for every API and routes to web page
@@ -18,6 +26,10 @@ type webHandler struct {
 	dataDir  string
 	jwtKey   []byte
 	tplCache *template.Template
+
+	// revokedTokens maps ID of tokens that logged out to their expiration time
+	revokedTokens map[string]time.Time
+	revokedMx     sync.Mutex
 }
 
 // newWebHandler returns new webHandler
@@ -31,50 +43,205 @@ func newWebHandler(db dt.Database, dataDir string) (*webHandler, error) {
 
 	// Create handler
 	handler := &webHandler{
-		db:      db,
-		dataDir: dataDir,
-		jwtKey:  jwtKey,
+		db:            db,
+		dataDir:       dataDir,
+		jwtKey:        jwtKey,
+		revokedTokens: make(map[string]time.Time),
 	}
 
 	return handler, nil
 }
 
-func (h *webHandler) checkToken(r *http.Request) error {
-	tokenCookie, err := r.Cookie("token")
-	if err != nil {
-		return fmt.Errorf("Token error: Token does not exist")
+// httpError is an error that should be sent to client with its status code.
+// Handlers panic with it, like with any other error, and the panic handler
+// of router uses the status code in response.
+type httpError struct {
+	code    int
+	message string
+}
+
+func (e httpError) Error() string {
+	return e.message
+}
+
+// newHTTPError returns httpError with the status code and formatted message.
+func newHTTPError(code int, format string, args ...interface{}) httpError {
+	return httpError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// decodeRequest decodes JSON body of request into v.
+// It panics with bad request error if the body is not valid.
+func decodeRequest(r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		panic(newHTTPError(http.StatusBadRequest, "Request is not valid: %v", err))
 	}
+}
 
-	token, err := jwt.Parse(tokenCookie.Value, h.jwtKeyFunc)
+// tokenAccount is the logged in account, as described by its token.
+type tokenAccount struct {
+	ID        int
+	IsAdmin   bool
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// contextKey is type of keys for values that saved in request context.
+type contextKey string
+
+const accountContextKey contextKey = "account"
+
+// ownerFilter returns the account ID used to filter bookmarks.
+// Admin can access bookmarks of every account.
+func (a tokenAccount) ownerFilter() int {
+	if a.IsAdmin {
+		return 0
+	}
+	return a.ID
+}
+
+// canAccess checks if the account may see and modify the bookmark.
+func (a tokenAccount) canAccess(book model.Bookmark) bool {
+	return a.IsAdmin || book.AccountID == 0 || book.AccountID == a.ID
+}
+
+// checkToken checks the token in cookie and returns the logged in account.
+func (h *webHandler) checkToken(r *http.Request) (tokenAccount, error) {
+	tokenCookie, err := r.Cookie("token")
 	if err != nil {
-		return fmt.Errorf("Token error: %v", err)
+		return tokenAccount{}, fmt.Errorf("Token error: Token does not exist")
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	err = claims.Valid()
+	token, err := jwt.Parse(tokenCookie.Value, h.jwtKeyFunc)
 	if err != nil {
-		return fmt.Errorf("Token error: %v", err)
+		return tokenAccount{}, fmt.Errorf("Token error: %v", err)
 	}
 
-	return nil
+	return h.parseTokenAccount(r.Context(), token)
 }
 
-func (h *webHandler) checkAPIToken(r *http.Request) error {
+// checkAPIToken checks the token in Authorization header, or in cookie if the header
+// is missing, and returns the logged in account. Beside the JWT from login, the header
+// may contain an API token created for the account.
+func (h *webHandler) checkAPIToken(r *http.Request) (tokenAccount, error) {
 	token, err := request.ParseFromRequest(r,
 		request.AuthorizationHeaderExtractor,
 		h.jwtKeyFunc)
-	if err != nil {
-		// Try to check in cookie
-		return h.checkToken(r)
+	if err == nil {
+		return h.parseTokenAccount(r.Context(), token)
 	}
 
+	// API token is plain hex string, while JWT always contains dots
+	bearer, _ := request.AuthorizationHeaderExtractor.ExtractToken(r)
+	if bearer != "" && !strings.Contains(bearer, ".") {
+		account, err := h.db.ResolveAPIToken(r.Context(), bearer)
+		if err != nil {
+			return tokenAccount{}, fmt.Errorf("Token error: %v", err)
+		}
+		return tokenAccount{ID: account.ID, IsAdmin: account.IsAdmin}, nil
+	}
+
+	// Try to check in cookie
+	return h.checkToken(r)
+}
+
+// isAdminRequest returns whether the request is sent by an admin, using either the session
+// from login or an API token.
+func (h *webHandler) isAdminRequest(r *http.Request) bool {
+	account, err := h.checkAPIToken(r)
+	return err == nil && account.IsAdmin
+}
+
+// parseTokenAccount validates the claims of token and returns the account it describes.
+// The account must still exist, and its current admin role is used instead of the one
+// at the time the token was issued.
+func (h *webHandler) parseTokenAccount(ctx context.Context, token *jwt.Token) (tokenAccount, error) {
 	claims := token.Claims.(jwt.MapClaims)
-	err = claims.Valid()
+	err := claims.Valid()
+	if err != nil {
+		return tokenAccount{}, fmt.Errorf("Token error: %v", err)
+	}
+
+	// JSON numbers are decoded as float64
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return tokenAccount{}, fmt.Errorf("Token error: Token has no account")
+	}
+
+	tokenID, _ := claims["jti"].(string)
+	if h.isTokenRevoked(tokenID) {
+		return tokenAccount{}, fmt.Errorf("Token error: Token has been revoked")
+	}
+
+	account, err := h.db.GetAccountByID(ctx, int(sub))
+	if errors.Is(err, dt.ErrAccountNotFound) {
+		return tokenAccount{}, fmt.Errorf("Token error: Account no longer exists")
+	}
 	if err != nil {
-		return fmt.Errorf("Token error: %v", err)
+		return tokenAccount{}, err
+	}
+
+	exp, _ := claims["exp"].(float64)
+	return tokenAccount{
+		ID:        account.ID,
+		IsAdmin:   account.IsAdmin,
+		TokenID:   tokenID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// revokeToken makes the token of account invalid, even if it's not expired yet.
+func (h *webHandler) revokeToken(account tokenAccount) {
+	if account.TokenID == "" {
+		return
 	}
 
-	return nil
+	h.revokedMx.Lock()
+	defer h.revokedMx.Unlock()
+
+	// Expired tokens are rejected anyway, so no need to remember them
+	now := time.Now()
+	for tokenID, expiresAt := range h.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(h.revokedTokens, tokenID)
+		}
+	}
+
+	h.revokedTokens[account.TokenID] = account.ExpiresAt
+}
+
+// isTokenRevoked checks if the token with the ID has been logged out.
+func (h *webHandler) isTokenRevoked(tokenID string) bool {
+	if tokenID == "" {
+		return false
+	}
+
+	h.revokedMx.Lock()
+	defer h.revokedMx.Unlock()
+
+	_, revoked := h.revokedTokens[tokenID]
+	return revoked
+}
+
+// requireAPIToken wraps handle so it's only called for request with valid token.
+// Otherwise the request is rejected with status 401. The logged in account
+// is saved in request context, and can be fetched by requestAccount.
+func (h *webHandler) requireAPIToken(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		account, err := h.checkAPIToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accountContextKey, account)
+		handle(w, r.WithContext(ctx), ps)
+	}
+}
+
+// requestAccount returns the logged in account saved by requireAPIToken.
+func requestAccount(r *http.Request) tokenAccount {
+	account, _ := r.Context().Value(accountContextKey).(tokenAccount)
+	return account
 }
 
 func (h *webHandler) jwtKeyFunc(token *jwt.Token) (interface{}, error) {
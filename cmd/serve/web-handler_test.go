@@ -0,0 +1,311 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// newTestHandler creates web handler backed by a new SQLite database,
+// and returns it together with the router that serves it.
+func newTestHandler(t *testing.T) (*webHandler, http.Handler) {
+	t.Helper()
+
+	db, err := dt.OpenSQLiteDatabase(fp.Join(t.TempDir(), "shiori.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	hdl, err := newWebHandler(db, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	return hdl, newRouter(hdl)
+}
+
+// createTestAccount creates account with username, and returns it together with
+// an API token that can be sent as bearer token.
+func createTestAccount(t *testing.T, hdl *webHandler, username string, isAdmin bool) (model.Account, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := hdl.db.CreateAccount(ctx, username, "password", isAdmin); err != nil {
+		t.Fatalf("failed to create account %s: %v", username, err)
+	}
+
+	account, err := hdl.db.GetAccount(ctx, username)
+	if err != nil {
+		t.Fatalf("failed to get account %s: %v", username, err)
+	}
+
+	token, err := hdl.db.CreateAPIToken(ctx, account.ID, "test")
+	if err != nil {
+		t.Fatalf("failed to create token of %s: %v", username, err)
+	}
+
+	return account, token
+}
+
+// createTestBookmark saves bookmark with url and tags owned by account with matching id.
+func createTestBookmark(t *testing.T, hdl *webHandler, accountID int, url string, tags ...string) model.Bookmark {
+	t.Helper()
+
+	book := model.Bookmark{URL: url, Title: url, Content: "content of " + url, AccountID: accountID}
+	for _, tag := range tags {
+		book.Tags = append(book.Tags, model.Tag{Name: tag})
+	}
+	if err := hdl.db.InsertBookmark(context.Background(), &book); err != nil {
+		t.Fatalf("failed to save %s: %v", url, err)
+	}
+	return book
+}
+
+// doRequest sends request to router and returns the recorded response.
+// If token is not empty, it's sent as bearer token.
+func doRequest(router http.Handler, method, path, token string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBookmarkIDs decodes list of bookmarks in body, and returns the set of their IDs.
+func decodeBookmarkIDs(t *testing.T, body io.Reader) map[int]bool {
+	t.Helper()
+
+	var bookmarks []model.Bookmark
+	if err := json.NewDecoder(body).Decode(&bookmarks); err != nil {
+		t.Fatalf("failed to decode bookmarks: %v", err)
+	}
+
+	ids := make(map[int]bool, len(bookmarks))
+	for _, book := range bookmarks {
+		ids[book.ID] = true
+	}
+	return ids
+}
+
+func TestAccountCanOnlyReadItsBookmarks(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	_, bobToken := createTestAccount(t, hdl, "bob", false)
+
+	book := createTestBookmark(t, hdl, alice.ID, "https://example.com/alice")
+	err := hdl.db.SaveThumbnail(context.Background(), book.ID, []byte("thumbnail"), "image/png")
+	if err != nil {
+		t.Fatalf("failed to save thumbnail: %v", err)
+	}
+
+	cachePath := "/bookmark/" + strconv.Itoa(book.ID)
+	thumbPath := "/thumb/" + strconv.Itoa(book.ID)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		code  int
+	}{
+		{"cache without login", cachePath, "", http.StatusUnauthorized},
+		{"cache of other account", cachePath, bobToken, http.StatusNotFound},
+		{"thumbnail without login", thumbPath, "", http.StatusUnauthorized},
+		{"thumbnail of other account", thumbPath, bobToken, http.StatusNotFound},
+		{"thumbnail of owner", thumbPath, aliceToken, http.StatusOK},
+		{"export of other account", cachePath + "/export", bobToken, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", tt.path, tt.token, nil)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestAccountOnlyListsItsBookmarks(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, aliceToken := createTestAccount(t, hdl, "alice", false)
+	bob, bobToken := createTestAccount(t, hdl, "bob", false)
+
+	aliceBook := createTestBookmark(t, hdl, alice.ID, "https://example.com/alice")
+	bobBook := createTestBookmark(t, hdl, bob.ID, "https://example.com/bob")
+
+	tests := []struct {
+		token   string
+		visible int
+		hidden  int
+	}{
+		{aliceToken, aliceBook.ID, bobBook.ID},
+		{bobToken, bobBook.ID, aliceBook.ID},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", "/api/bookmarks", tt.token, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		ids := decodeBookmarkIDs(t, rec.Body)
+		if !ids[tt.visible] || ids[tt.hidden] {
+			t.Errorf("got bookmarks %v, want %d without %d", ids, tt.visible, tt.hidden)
+		}
+	}
+}
+
+func TestAccountCantModifyOtherBookmarks(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	alice, _ := createTestAccount(t, hdl, "alice", false)
+	_, bobToken := createTestAccount(t, hdl, "bob", false)
+
+	book := createTestBookmark(t, hdl, alice.ID, "https://example.com/alice")
+
+	rec := doRequest(router, "DELETE", "/api/bookmarks/"+strconv.Itoa(book.ID), bobToken, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	_, found, err := hdl.db.GetBookmark(context.Background(), book.ID, false)
+	if err != nil || !found {
+		t.Errorf("bookmark of other account has been deleted, err %v", err)
+	}
+}
+
+func TestLoginSession(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	createTestAccount(t, hdl, "alice", false)
+
+	rec := doRequest(router, "POST", "/api/login", "", strings.NewReader(`{"username":"alice","password":"password"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	session := rec.Body.String()
+
+	// The session is sent in cookie by the web interface
+	getBookmarks := func(cookie string) int {
+		req := httptest.NewRequest("GET", "/api/bookmarks", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := getBookmarks(""); code != http.StatusUnauthorized {
+		t.Errorf("without cookie got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := getBookmarks("invalid"); code != http.StatusUnauthorized {
+		t.Errorf("with invalid cookie got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := getBookmarks(session); code != http.StatusOK {
+		t.Errorf("with session cookie got status %d, want %d", code, http.StatusOK)
+	}
+
+	// Logging out invalidates the session, even if the cookie is kept
+	rec = doRequest(router, "POST", "/api/logout", session, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if code := getBookmarks(session); code != http.StatusUnauthorized {
+		t.Errorf("after logout got status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	ctx := context.Background()
+
+	alice, validToken := createTestAccount(t, hdl, "alice", false)
+	revokedToken, err := hdl.db.CreateAPIToken(ctx, alice.ID, "revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := hdl.db.GetAPITokens(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.TokenHash == validToken || token.TokenHash == revokedToken {
+			t.Errorf("token %q is saved in plain text", token.Label)
+		}
+		if token.Label == "revoked" {
+			if err := hdl.db.DeleteAPITokens(ctx, token.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid token", validToken, http.StatusOK},
+		{"revoked token", revokedToken, http.StatusUnauthorized},
+		{"unknown token", strings.Repeat("0", len(validToken)), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		rec := doRequest(router, "GET", "/api/bookmarks", tt.token, nil)
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestLoginSessionFollowsAccount(t *testing.T) {
+	hdl, router := newTestHandler(t)
+	ctx := context.Background()
+	createTestAccount(t, hdl, "admin", true)
+	bob, _ := createTestAccount(t, hdl, "bob", false)
+	book := createTestBookmark(t, hdl, bob.ID, "https://example.com/bob")
+
+	rec := doRequest(router, "POST", "/api/login", "", strings.NewReader(`{"username":"admin","password":"password"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	session := rec.Body.String()
+
+	listsBook := func() bool {
+		rec := doRequest(router, "GET", "/api/bookmarks", session, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+		}
+		return decodeBookmarkIDs(t, rec.Body)[book.ID]
+	}
+
+	if !listsBook() {
+		t.Error("admin can't see bookmark of bob")
+	}
+
+	// Revoking admin role applies to the session that already logged in
+	if err := hdl.db.SetAccountAdmin(ctx, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	if listsBook() {
+		t.Error("bookmark of bob is still listed after admin role is revoked")
+	}
+
+	// So does deleting the account
+	if err := hdl.db.DeleteAccounts(ctx, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := doRequest(router, "GET", "/api/bookmarks", session, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("deleted account got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,117 @@
+package tag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	dt "src.techknowlogick.com/shiori/database"
+)
+
+// cmdHandler is handler for all action in TagCmd
+type cmdHandler struct {
+	db dt.Database
+}
+
+// cleanTags is handler for deleting tags that not used by any bookmarks.
+func (h *cmdHandler) cleanTags(cmd *cobra.Command, args []string) {
+	// In dry run, only show the tags that would be deleted
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	if dryRun {
+		tags, err := h.db.GetTags(context.Background(), 0)
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		// Tags of bookmarks in trash are not counted, but they're still used
+		unusedTags, err := h.db.GetUnusedTags(context.Background())
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		nUnused, nEmpty := 0, 0
+		for _, tag := range tags {
+			if strings.TrimSpace(tag.Name) == "" {
+				nEmpty++
+			}
+		}
+		for _, tag := range unusedTags {
+			if strings.TrimSpace(tag.Name) != "" {
+				cTag.Println(tag.Name)
+				nUnused++
+			}
+		}
+
+		fmt.Printf("%d unused tag(s) and %d empty tag(s) would be deleted\n", nUnused, nEmpty)
+		return
+	}
+
+	nEmpty, err := h.db.DeleteEmptyTags(context.Background())
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	if nEmpty > 0 {
+		fmt.Printf("%d empty tag(s) have been deleted\n", nEmpty)
+	}
+
+	nDeleted, err := h.db.DeleteUnusedTags(context.Background())
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	fmt.Printf("%d unused tag(s) have been deleted\n", nDeleted)
+}
+
+// listTags is handler for printing all tags and the number of their bookmarks.
+func (h *cmdHandler) listTags(cmd *cobra.Command, args []string) {
+	// Parse flags
+	sortBy, _ := cmd.Flags().GetString("sort")
+	useJSON, _ := cmd.Flags().GetBool("json")
+
+	tags, err := h.db.GetTags(context.Background(), 0)
+	if err != nil {
+		cError.Println(err)
+		return
+	}
+
+	switch sortBy {
+	case "name":
+		sort.SliceStable(tags, func(i, j int) bool {
+			return tags[i].Name < tags[j].Name
+		})
+	case "count":
+		sort.SliceStable(tags, func(i, j int) bool {
+			if tags[i].NBookmark != tags[j].NBookmark {
+				return tags[i].NBookmark > tags[j].NBookmark
+			}
+			return tags[i].Name < tags[j].Name
+		})
+	default:
+		cError.Printf("Unknown sort order %s, use either name or count\n", sortBy)
+		return
+	}
+
+	if useJSON {
+		bt, err := json.MarshalIndent(&tags, "", "    ")
+		if err != nil {
+			cError.Println(err)
+			return
+		}
+
+		fmt.Println(string(bt))
+		return
+	}
+
+	for _, tag := range tags {
+		cTag.Print(tag.Name)
+		fmt.Printf(" (%d)\n", tag.NBookmark)
+	}
+}
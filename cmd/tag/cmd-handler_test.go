@@ -0,0 +1,118 @@
+package tag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+
+	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// openTestDatabase opens a new SQLite database with a bookmark for each list of tags.
+func openTestDatabase(t *testing.T, tags ...[]string) dt.Database {
+	t.Helper()
+
+	db, err := dt.OpenSQLiteDatabase(fp.Join(t.TempDir(), "shiori.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for i, names := range tags {
+		book := model.Bookmark{URL: fmt.Sprintf("https://example.com/%d", i), Title: "Example"}
+		for _, name := range names {
+			book.Tags = append(book.Tags, model.Tag{Name: name})
+		}
+		if err := db.InsertBookmark(context.Background(), &book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// runTagCommand runs the tag command with args, and returns what it printed to stdout.
+func runTagCommand(t *testing.T, db dt.Database, args ...string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	cmd := NewTagCmd(db)
+	cmd.SetArgs(args)
+	err = cmd.Execute()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("tag %v: %v", args, err)
+	}
+
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestListTags(t *testing.T) {
+	db := openTestDatabase(t, []string{"go", "web"}, []string{"go"}, []string{"rust", "web"}, []string{"go"})
+
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"name", "[go:3 rust:1 web:2]"},
+		{"count", "[go:3 web:2 rust:1]"},
+	}
+
+	for _, tt := range tests {
+		output := runTagCommand(t, db, "list", "--json", "--sort", tt.sort)
+
+		var tags []model.Tag
+		if err := json.Unmarshal([]byte(output), &tags); err != nil {
+			t.Fatalf("sort %s: %v", tt.sort, err)
+		}
+
+		got := make([]string, len(tags))
+		for i, tag := range tags {
+			got[i] = fmt.Sprintf("%s:%d", tag.Name, tag.NBookmark)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("sort %s: got %v, want %s", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestCleanTagsDryRun(t *testing.T) {
+	db := openTestDatabase(t, []string{"go"}, []string{"unused"})
+	ctx := context.Background()
+
+	// The tag is kept after its only bookmark is gone
+	if err := db.PurgeBookmarks(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runTagCommand(t, db, "clean", "--dry-run")
+	if !strings.Contains(output, "1 unused tag(s) and 0 empty tag(s) would be deleted") {
+		t.Errorf("got output %q, want one unused tag", output)
+	}
+
+	tags, err := db.GetTags(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("got %d tags after dry run, want both kept", len(tags))
+	}
+}
@@ -0,0 +1,50 @@
+package tag
+
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	dt "src.techknowlogick.com/shiori/database"
+)
+
+var (
+	cError = color.New(color.FgHiRed)
+	cTag   = color.New(color.FgHiBlue)
+)
+
+// NewTagCmd creates new command for managing tags
+func NewTagCmd(db dt.Database) *cobra.Command {
+	// Create handler
+	hdl := cmdHandler{db: db}
+
+	// Create sub command
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Delete tags that not used by any bookmarks",
+		Long: "Delete tags that not used by any bookmarks, " +
+			"and tags with empty name which may be saved by older version.",
+		Args: cobra.NoArgs,
+		Run:  hdl.cleanTags,
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "Print all tags and the number of their bookmarks",
+		Args:    cobra.NoArgs,
+		Aliases: []string{"ls", "print"},
+		Run:     hdl.listTags,
+	}
+
+	// Set sub command flags
+	cleanCmd.Flags().Bool("dry-run", false, "Only print the tags that would be deleted")
+	listCmd.Flags().StringP("sort", "s", "name", "Sort tags by name or count")
+	listCmd.Flags().BoolP("json", "j", false, "Output data in JSON format")
+
+	// Create final root command
+	rootCmd := &cobra.Command{
+		Use:   "tag",
+		Short: "Manage tags of the saved bookmarks",
+	}
+
+	rootCmd.AddCommand(cleanCmd, listCmd)
+	return rootCmd
+}
@@ -2,17 +2,11 @@ package cmd
 
 import (
 	"errors"
-	"io"
-	"net/http"
-	nurl "net/url"
 	"os"
 	"os/exec"
-	fp "path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
-	"unicode/utf8"
 
 	"github.com/fatih/color"
 	"golang.org/x/crypto/ssh/terminal"
@@ -35,51 +29,9 @@ func normalizeSpace(str string) string {
 	return strings.Join(strings.Fields(str), " ")
 }
 
-func clearUTMParams(url *nurl.URL) {
-	newQuery := nurl.Values{}
-	for key, value := range url.Query() {
-		if !strings.HasPrefix(key, "utm_") {
-			newQuery[key] = value
-		}
-	}
-
-	url.RawQuery = newQuery.Encode()
-}
-
-func downloadFile(url, dstPath string, timeout time.Duration) error {
-	// Fetch data from URL
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Make sure destination directory exist
-	err = os.MkdirAll(fp.Dir(dstPath), os.ModePerm)
-	if err != nil {
-		return err
-	}
-
-	// Create destination file
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	// Write response body to the file
-	_, err = io.Copy(dst, resp.Body)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // openBrowser tries to open the URL in a browser,
-// and returns whether it succeed in doing so.
-func openBrowser(url string) error {
+// and returns whether it succeed in doing so. Tests replace it to not start a browser.
+var openBrowser = func(url string) error {
 	var args []string
 	switch runtime.GOOS {
 	case "darwin":
@@ -136,10 +88,3 @@ func checkError(err error) {
 		panic(err)
 	}
 }
-
-func fixUtf(r rune) rune {
-	if r == utf8.RuneError {
-		return -1
-	}
-	return r
-}
@@ -1,45 +1,366 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"src.techknowlogick.com/shiori/model"
 )
 
+// ErrInvalidCredentials is returned by VerifyAccount when the username doesn't exist
+// or the password doesn't match, so the caller can't tell which one is wrong.
+var ErrInvalidCredentials = errors.New("Username and password don't match")
+
+// ErrBookmarkExists is returned by InsertBookmark when the URL of bookmark already saved.
+var ErrBookmarkExists = errors.New("Bookmark with the same URL already exists")
+
+// ErrInvalidBookmark is returned when bookmark can't be saved since its URL or title is not valid.
+var ErrInvalidBookmark = errors.New("Bookmark is not valid")
+
+// ErrAccountNotFound is returned by GetAccountByID when there is no account with matching ID.
+var ErrAccountNotFound = errors.New("Account doesn't exist")
+
+// ErrInvalidAPIToken is returned by ResolveAPIToken when the token doesn't exist
+// or its account has been removed.
+var ErrInvalidAPIToken = errors.New("API token is invalid")
+
 // Database is interface for manipulating data in database.
 type Database interface {
 	// InsertBookmark inserts new bookmark to database.
-	InsertBookmark(bookmark *model.Bookmark) error
+	// Returns ErrBookmarkExists if its URL already saved.
+	InsertBookmark(ctx context.Context, bookmark *model.Bookmark) error
+
+	// InsertBookmarks inserts many new bookmarks to database in one transaction.
+	// Returns IDs of the new bookmarks, in the same order as bookmarks, or ErrBookmarkExists
+	// if URL of any of them already saved. If progress is not nil, it's called with the number
+	// of inserted bookmarks as they're inserted.
+	InsertBookmarks(ctx context.Context, bookmarks []model.Bookmark, progress ProgressFunc) ([]int, error)
+
+	// UpsertBookmark inserts new bookmark or, if its URL already saved, updates the saved one.
+	// Returns ID of the bookmark and whether it's newly created.
+	UpsertBookmark(ctx context.Context, bookmark model.Bookmark) (int, bool, error)
+
+	// GetBookmarks fetch list of bookmarks based on submitted ids. Unless opts has its own
+	// ordering, the bookmarks are returned in the same order as ids.
+	GetBookmarks(ctx context.Context, withContent bool, opts ListOptions, ids ...int) ([]model.Bookmark, error)
+
+	// GetBookmark fetch bookmark with matching id. Returns false if it doesn't exist.
+	GetBookmark(ctx context.Context, id int, withContent bool) (model.Bookmark, bool, error)
+
+	// GetBookmarksModifiedSince fetch bookmarks that saved, updated or moved to trash after t,
+	// oldest first. If accountID is not zero, only the bookmarks accessible by account with
+	// matching id are fetched.
+	GetBookmarksModifiedSince(ctx context.Context, accountID int, t time.Time, withContent bool) ([]model.Bookmark, error)
+
+	// GetUntaggedBookmarks fetch list of bookmarks that don't have any tags. If accountID is
+	// not zero, only the bookmarks accessible by account with matching id are fetched.
+	GetUntaggedBookmarks(ctx context.Context, accountID int, withContent bool) ([]model.Bookmark, error)
 
-	// GetBookmarks fetch list of bookmarks based on submitted ids.
-	GetBookmarks(withContent bool, ids ...int) ([]model.Bookmark, error)
+	// GetTags fetch list of tags and their frequency. Non zero accountID limits
+	// them to the tags of bookmarks accessible by that account.
+	GetTags(ctx context.Context, accountID int) ([]model.Tag, error)
 
-	// GetTags fetch list of tags and their frequency
-	GetTags() ([]model.Tag, error)
+	// GetAuthors fetch the distinct authors of bookmarks, sorted by name.
+	// Bookmarks without author are skipped. Non zero accountID limits the bookmarks
+	// to the ones accessible by that account.
+	GetAuthors(ctx context.Context, accountID int) ([]string, error)
 
-	// DeleteBookmarks removes all record with matching ids from database.
-	DeleteBookmarks(ids ...int) error
+	// GetDomains fetch the domains of bookmarks and the number of bookmarks in each of them,
+	// the most bookmarked domain first. Non zero accountID limits the bookmarks to the
+	// ones accessible by that account.
+	GetDomains(ctx context.Context, accountID int) ([]model.DomainCount, error)
+
+	// BackfillDomains fills the domain of bookmarks saved before the domain column existed,
+	// and returns the number of filled bookmarks. It's run once by the migrations.
+	BackfillDomains(ctx context.Context) (int, error)
+
+	// GetTagsForSearch fetch list of tags and their frequency within the bookmarks
+	// that matched by keyword and tags, sorted by name. Non zero accountID limits the
+	// bookmarks to the ones accessible by that account.
+	GetTagsForSearch(ctx context.Context, accountID int, keyword string, tags ...string) ([]model.Tag, error)
+
+	// SearchTags fetch tags whose name starts with prefix, most used first. Non zero
+	// accountID limits them to the tags of bookmarks accessible by that account.
+	SearchTags(ctx context.Context, accountID int, prefix string, limit int) ([]model.Tag, error)
+
+	// GetRelatedTags fetch tags that often assigned together with tag with matching id.
+	// Non zero accountID limits the bookmarks to the ones accessible by that account.
+	GetRelatedTags(ctx context.Context, accountID int, tagID int, limit int) ([]model.Tag, error)
+
+	// GetTagsForBookmark fetch tags of bookmark with matching id, sorted by name.
+	GetTagsForBookmark(ctx context.Context, bookmarkID int) ([]model.Tag, error)
+
+	// DeleteBookmarks moves bookmarks with matching ids to trash.
+	// If no ids given, nothing is deleted.
+	DeleteBookmarks(ctx context.Context, ids ...int) error
+
+	// DeleteAllBookmarks moves every bookmark to trash.
+	DeleteAllBookmarks(ctx context.Context) error
+
+	// RestoreBookmarks moves bookmarks with matching ids back from trash.
+	RestoreBookmarks(ctx context.Context, ids ...int) error
+
+	// PurgeBookmarks permanently removes bookmarks with matching ids from database.
+	PurgeBookmarks(ctx context.Context, ids ...int) error
 
 	// SearchBookmarks search bookmarks by the keyword or tags.
-	SearchBookmarks(orderLatest bool, keyword string, tags ...string) ([]model.Bookmark, error)
+	// Returns the requested page of bookmarks and the total count of matching bookmarks.
+	SearchBookmarks(ctx context.Context, opts SearchOptions) ([]model.Bookmark, int, error)
 
 	// UpdateBookmarks updates the saved bookmark in database.
-	UpdateBookmarks(bookmarks ...model.Bookmark) ([]model.Bookmark, error)
+	UpdateBookmarks(ctx context.Context, bookmarks ...model.Bookmark) ([]model.Bookmark, error)
+
+	// SaveArchive saves archived copy of a bookmark, replacing the old one if any.
+	SaveArchive(ctx context.Context, bookmarkID int, data []byte, mime string) error
+
+	// GetArchive fetch archived copy of a bookmark and its MIME type.
+	GetArchive(ctx context.Context, bookmarkID int) ([]byte, string, error)
+
+	// SaveThumbnail saves image of a bookmark, replacing the old one if any.
+	SaveThumbnail(ctx context.Context, bookmarkID int, data []byte, mime string) error
+
+	// GetThumbnail fetch image of a bookmark and its MIME type.
+	GetThumbnail(ctx context.Context, bookmarkID int) ([]byte, string, error)
 
 	// CreateAccount creates new account in database
-	CreateAccount(username, password string) error
+	CreateAccount(ctx context.Context, username, password string, isAdmin bool) error
+
+	// SetAccountAdmin grants or revokes the admin role of account with matching username.
+	SetAccountAdmin(ctx context.Context, username string, admin bool) error
 
 	// GetAccount fetch account with matching username
-	GetAccount(username string) (model.Account, error)
+	GetAccount(ctx context.Context, username string) (model.Account, error)
+
+	// GetAccountByID fetch account with matching ID. Returns ErrAccountNotFound if it doesn't exist.
+	GetAccountByID(ctx context.Context, id int) (model.Account, error)
+
+	// UpdateAccountPassword changes the password of account with matching username.
+	UpdateAccountPassword(ctx context.Context, username, newPassword string) error
+
+	// VerifyAccount fetch account with matching username and checks its password.
+	VerifyAccount(ctx context.Context, username, password string) (model.Account, error)
+
+	// GetAccounts fetch list of accounts with matching keyword.
+	// If exactMatch is true, the username must equal to the keyword, ignoring case.
+	GetAccounts(ctx context.Context, keyword string, exactMatch bool) ([]model.Account, error)
+
+	// DeleteAccounts removes all record with matching usernames.
+	// If no usernames given, nothing is deleted.
+	DeleteAccounts(ctx context.Context, usernames ...string) error
+
+	// DeleteAllAccounts removes every account.
+	DeleteAllAccounts(ctx context.Context) error
+
+	// CreateAPIToken creates new API token for account with matching id.
+	// Returns the token, which can't be retrieved again later.
+	CreateAPIToken(ctx context.Context, accountID int, label string) (string, error)
+
+	// ResolveAPIToken fetch account that owns the token.
+	ResolveAPIToken(ctx context.Context, token string) (model.Account, error)
+
+	// GetAPITokens fetch list of API tokens owned by account with matching id.
+	GetAPITokens(ctx context.Context, accountID int) ([]model.APIToken, error)
+
+	// DeleteAPITokens revokes API tokens with matching ids.
+	DeleteAPITokens(ctx context.Context, ids ...int) error
+
+	// UpdateTag changes the name and description of tag with matching id.
+	UpdateTag(ctx context.Context, id int, name, description string) error
+
+	// RenameTag renames a tag. If a tag with the new name already exists,
+	// both tags are merged into it.
+	RenameTag(ctx context.Context, oldName, newName string) error
+
+	// MergeTags moves all bookmarks of the source tags to the target tag,
+	// then removes the source tags.
+	MergeTags(ctx context.Context, sourceIDs []int, targetID int) error
+
+	// DeleteTags removes tags with matching ids, including tags that still used by bookmarks.
+	DeleteTags(ctx context.Context, ids ...int) error
+
+	// GetUnusedTags fetch the tags that not used by any bookmarks, including the ones in trash.
+	// They are the tags that removed by DeleteUnusedTags.
+	GetUnusedTags(ctx context.Context) ([]model.Tag, error)
+
+	// DeleteUnusedTags removes all tags that not used by any bookmarks.
+	// Returns the count of removed tags.
+	DeleteUnusedTags(ctx context.Context) (int, error)
+
+	// DeleteEmptyTags removes tags whose name is empty or only whitespace.
+	// Returns the count of removed tags.
+	DeleteEmptyTags(ctx context.Context) (int, error)
+
+	// RecomputeReadTimes estimates reading time of every bookmark from its content.
+	// Returns the number of updated bookmarks.
+	RecomputeReadTimes(ctx context.Context) (int, error)
+
+	// UpdateBookmarkTags adds and removes tags of a bookmark without updating the bookmark itself.
+	UpdateBookmarkTags(ctx context.Context, bookmarkID int, addTags []string, removeTags []string) error
 
-	// GetAccounts fetch list of accounts with matching keyword
-	GetAccounts(keyword string) ([]model.Account, error)
+	// AddTagToBookmarks assigns a tag to all bookmarks with matching ids that not in trash.
+	AddTagToBookmarks(ctx context.Context, tagName string, bookmarkIDs []int) error
 
-	// DeleteAccounts removes all record with matching usernames
-	DeleteAccounts(usernames ...string) error
+	// MarkRead sets the read status of bookmarks with matching ids.
+	MarkRead(ctx context.Context, ids []int, read bool) error
+
+	// MarkFavorite sets the favorite status of bookmarks with matching ids.
+	MarkFavorite(ctx context.Context, ids []int, favorite bool) error
+
+	// FindBookmarksByURLPrefix fetch bookmarks whose URL starts with prefix.
+	FindBookmarksByURLPrefix(ctx context.Context, prefix string) ([]model.Bookmark, error)
+
+	// FindDuplicateBookmarks fetch bookmarks whose URL is the same as url
+	// after both are normalized by NormalizeURL.
+	FindDuplicateBookmarks(ctx context.Context, url string) ([]model.Bookmark, error)
 
 	// GetBookmarkID fetchs bookmark ID based by its url
-	GetBookmarkID(url string) int
+	GetBookmarkID(ctx context.Context, url string) int
+
+	// GetStats returns the summary of bookmarks that accessible by account with matching id.
+	// If accountID is 0, every bookmark is counted.
+	GetStats(ctx context.Context, accountID int) (model.Stats, error)
+
+	// GetMaxModified returns the last time any bookmark that accessible by account with
+	// matching id is changed, including when it's moved to trash, and the number of those
+	// bookmarks including the ones in trash. If accountID is 0, every bookmark is checked.
+	GetMaxModified(ctx context.Context, accountID int) (time.Time, int, error)
+
+	// Ping makes sure the database is reachable by running a trivial query.
+	Ping(ctx context.Context) error
+
+	// Stats returns the statistics of database connection pool.
+	Stats() sql.DBStats
+
+	// Close closes the connections to database. Calling it more than once is harmless.
+	Close() error
+}
+
+// ListOptions limits the bookmarks returned by a query.
+type ListOptions struct {
+	// Limit is the max number of bookmarks returned. Zero means no limit.
+	Limit int
+
+	// Offset is the number of bookmarks skipped. Only used when Limit is set.
+	Offset int
+
+	// FavoriteFirst sorts the favorite bookmarks before the others.
+	FavoriteFirst bool
+
+	// OrderBy overrides the default order of the query.
+	OrderBy Order
+
+	// AccountID limits the result to bookmarks owned by this account,
+	// plus the bookmarks that don't have any owner (e.g. the ones added from CLI).
+	// Zero means bookmarks of every account are returned.
+	AccountID int
+
+	// IncludeDeleted includes the bookmarks in trash to the result.
+	IncludeDeleted bool
+}
+
+// Order is the sort order of a list of bookmarks.
+// It's the name of a sort key, prefixed with "-" to sort in descending order.
+type Order string
+
+// Supported sort orders.
+const (
+	OrderDefault      Order = ""
+	OrderIDAsc        Order = "id"
+	OrderIDDesc       Order = "-id"
+	OrderTitleAsc     Order = "title"
+	OrderTitleDesc    Order = "-title"
+	OrderModifiedAsc  Order = "modified"
+	OrderModifiedDesc Order = "-modified"
+	OrderCreatedAsc   Order = "created"
+	OrderCreatedDesc  Order = "-created"
+	OrderReadTimeAsc  Order = "read-time"
+	OrderReadTimeDesc Order = "-read-time"
+
+	// OrderRelevance sorts the bookmarks that most relevant to the searched keyword first.
+	// It's only used by SearchBookmarks, and sorts by id descending when there is no keyword.
+	OrderRelevance Order = "relevance"
+)
+
+// orderColumns maps the sort keys to the columns used to sort by them.
+// Only these columns are ever put into ORDER BY.
+var orderColumns = map[string][]string{
+	"id":        {"id"},
+	"title":     {"title"},
+	"modified":  {"modified"},
+	"created":   {"created"},
+	"read-time": {"min_read_time", "max_read_time"},
+}
+
+// ParseOrder validates the sort order submitted by user, e.g. "title" or "-modified".
+// Empty string is the default order.
+func ParseOrder(s string) (Order, error) {
+	if s == "" {
+		return OrderDefault, nil
+	}
+
+	if Order(s) == OrderRelevance {
+		return OrderRelevance, nil
+	}
+
+	if _, ok := orderColumns[strings.TrimPrefix(s, "-")]; !ok {
+		return OrderDefault, fmt.Errorf("Unknown sort order %s", s)
+	}
+	return Order(s), nil
+}
+
+// columns returns the columns used to sort by the order and whether it's descending.
+func (o Order) columns() ([]string, bool) {
+	return orderColumns[strings.TrimPrefix(string(o), "-")], strings.HasPrefix(string(o), "-")
+}
+
+// SearchOptions is the criteria used by SearchBookmarks.
+type SearchOptions struct {
+	ListOptions
+
+	// OrderLatest sorts the newest bookmarks first.
+	OrderLatest bool
+
+	// Keyword is matched against bookmark's url, title, content and note.
+	Keyword string
+
+	// Prefix matches the words of keyword against the beginning of words in title and content,
+	// e.g. "golan" matches "golang". By default only whole words are matched.
+	Prefix bool
+
+	// Snippet fills the snippet of each bookmark with the part of its content that matched
+	// keyword, where the matched words are wrapped in <b>. Other HTML in snippet is escaped.
+	Snippet bool
+
+	// Tags limits the result to bookmarks having at least one of these tags.
+	Tags []string
+
+	// Author limits the result to bookmarks written by this author.
+	Author string
+
+	// Domain limits the result to bookmarks whose URL is in this domain, e.g. "example.com".
+	Domain string
+
+	// StartDate and EndDate limit the result to bookmarks modified within this range.
+	// A zero value means the range is unbounded on that side.
+	StartDate time.Time
+	EndDate   time.Time
+
+	// ReadOnly limits the result to bookmarks marked as read,
+	// while UnreadOnly limits it to bookmarks not marked as read.
+	ReadOnly   bool
+	UnreadOnly bool
+
+	// FavoriteOnly limits the result to favorite bookmarks.
+	FavoriteOnly bool
+
+	// ArchivedOnly limits the result to bookmarks that have an archive,
+	// while UnarchivedOnly limits it to bookmarks that still need archiving.
+	ArchivedOnly   bool
+	UnarchivedOnly bool
 }
 
 func checkError(err error) {
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	nurl "net/url"
+	"strings"
+
+	"github.com/go-xorm/xorm"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// maxDomainLength is the size of domain column in bookmark table.
+const maxDomainLength = 255
+
+// fillDomain sets the domain of bookmark from its URL, so bookmarks can be grouped
+// and searched by domain without parsing URLs in SQL.
+func fillDomain(bookmark *model.Bookmark) {
+	bookmark.Domain = urlDomain(bookmark.URL)
+}
+
+// urlDomain returns the host of url without port, or empty string if url is not valid.
+// Valid host names are never longer than the domain column, but the host of a malformed URL
+// may be, so it's ignored.
+func urlDomain(url string) string {
+	parsedURL, err := nurl.Parse(strings.TrimSpace(url))
+	if err != nil {
+		return ""
+	}
+
+	domain := normalizeDomain(parsedURL.Hostname())
+	if len(domain) > maxDomainLength {
+		return ""
+	}
+	return domain
+}
+
+// normalizeDomain returns the form of domain that saved in database.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// BackfillDomains fills the domain of bookmarks saved before the domain column existed,
+// and returns the number of filled bookmarks. Bookmarks whose URL doesn't have a host
+// are left as they are.
+func (db *XormDatabase) BackfillDomains(ctx context.Context) (int, error) {
+	return backfillDomains(db.Context(ctx))
+}
+
+// backfillDomains is BackfillDomains using session, so it can be run by migration.
+func backfillDomains(session *xorm.Session) (int, error) {
+	var bookmarks []model.Bookmark
+	err := session.Unscoped().Cols("id", "url").
+		Where("domain = '' AND url <> ''").Find(&bookmarks)
+	if err != nil {
+		return 0, err
+	}
+
+	nFilled := 0
+	for _, bookmark := range bookmarks {
+		domain := urlDomain(bookmark.URL)
+		if domain == "" {
+			continue
+		}
+
+		_, err = session.Unscoped().Table("bookmark").Where("id = ?", bookmark.ID).
+			Update(map[string]interface{}{"domain": domain})
+		if err != nil {
+			return nFilled, err
+		}
+		nFilled++
+	}
+
+	return nFilled, nil
+}
@@ -0,0 +1,556 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// latencyBuckets is the upper bounds in seconds of the buckets in latency histogram.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// DefaultSlowThreshold is the duration after which a database call is logged as slow.
+const DefaultSlowThreshold = 200 * time.Millisecond
+
+// Logger is used by MetricsDatabase to log the calls of database methods.
+// It's satisfied by logrus.FieldLogger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// MetricsDatabase wraps another Database, and records the number of calls, the number of errors
+// and the latency of each of its methods. The metrics can be written in Prometheus text format
+// using WriteMetrics.
+type MetricsDatabase struct {
+	Database
+
+	mx      sync.Mutex
+	methods map[string]*methodMetrics
+
+	logger        Logger
+	slowThreshold time.Duration
+}
+
+type methodMetrics struct {
+	calls   uint64
+	errors  uint64
+	buckets []uint64
+	seconds float64
+}
+
+var _ Database = (*MetricsDatabase)(nil)
+
+// NewMetricsDatabase returns db which records the metrics of the wrapped db.
+func NewMetricsDatabase(db Database) *MetricsDatabase {
+	return &MetricsDatabase{
+		Database: db,
+		methods:  make(map[string]*methodMetrics),
+	}
+}
+
+// SetLogger makes db log every call with its duration in debug level, and the calls that
+// took longer than slowThreshold in warning level. It must be called before db is used.
+func (db *MetricsDatabase) SetLogger(logger Logger, slowThreshold time.Duration) {
+	db.logger = logger
+	db.slowThreshold = slowThreshold
+}
+
+// observe records a call of method which started at start.
+func (db *MetricsDatabase) observe(method string, start time.Time, err error) {
+	duration := time.Since(start)
+	seconds := duration.Seconds()
+
+	if db.logger != nil {
+		message := fmt.Sprintf("database: %s took %v", method, duration)
+		if err != nil {
+			message += fmt.Sprintf(", failed: %v", err)
+		}
+
+		if db.slowThreshold > 0 && duration > db.slowThreshold {
+			db.logger.Warnf("%s, slower than %v", message, db.slowThreshold)
+		} else {
+			db.logger.Debugf("%s", message)
+		}
+	}
+
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	metrics, ok := db.methods[method]
+	if !ok {
+		metrics = &methodMetrics{buckets: make([]uint64, len(latencyBuckets))}
+		db.methods[method] = metrics
+	}
+
+	metrics.calls++
+	metrics.seconds += seconds
+	if err != nil {
+		metrics.errors++
+	}
+
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			metrics.buckets[i]++
+		}
+	}
+}
+
+// WriteMetrics writes the recorded metrics and the statistics of connection pool
+// in Prometheus text format.
+func (db *MetricsDatabase) WriteMetrics(w io.Writer) error {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	methods := make([]string, 0, len(db.methods))
+	for method := range db.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	ew := &errWriter{w: w}
+	ew.printf("# HELP shiori_db_queries_total Number of calls of database methods.\n")
+	ew.printf("# TYPE shiori_db_queries_total counter\n")
+	for _, method := range methods {
+		ew.printf("shiori_db_queries_total{method=%q} %d\n", method, db.methods[method].calls)
+	}
+
+	ew.printf("# HELP shiori_db_errors_total Number of calls of database methods that returned error.\n")
+	ew.printf("# TYPE shiori_db_errors_total counter\n")
+	for _, method := range methods {
+		ew.printf("shiori_db_errors_total{method=%q} %d\n", method, db.methods[method].errors)
+	}
+
+	ew.printf("# HELP shiori_db_query_duration_seconds Latency of database methods.\n")
+	ew.printf("# TYPE shiori_db_query_duration_seconds histogram\n")
+	for _, method := range methods {
+		metrics := db.methods[method]
+		for i, bound := range latencyBuckets {
+			ew.printf("shiori_db_query_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", method, bound, metrics.buckets[i])
+		}
+		ew.printf("shiori_db_query_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, metrics.calls)
+		ew.printf("shiori_db_query_duration_seconds_sum{method=%q} %g\n", method, metrics.seconds)
+		ew.printf("shiori_db_query_duration_seconds_count{method=%q} %d\n", method, metrics.calls)
+	}
+
+	stats := db.Stats()
+	ew.printf("# HELP shiori_db_open_connections Number of open connections to database.\n")
+	ew.printf("# TYPE shiori_db_open_connections gauge\n")
+	ew.printf("shiori_db_open_connections %d\n", stats.OpenConnections)
+	ew.printf("# HELP shiori_db_in_use_connections Number of connections to database that currently in use.\n")
+	ew.printf("# TYPE shiori_db_in_use_connections gauge\n")
+	ew.printf("shiori_db_in_use_connections %d\n", stats.InUse)
+
+	return ew.err
+}
+
+// errWriter keeps the first error of its writes, so it only has to be checked once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+// The methods below only call the wrapped database, and record the call.
+
+func (db *MetricsDatabase) InsertBookmark(ctx context.Context, bookmark *model.Bookmark) error {
+	start := time.Now()
+	err := db.Database.InsertBookmark(ctx, bookmark)
+	db.observe("InsertBookmark", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) InsertBookmarks(ctx context.Context, bookmarks []model.Bookmark, progress ProgressFunc) ([]int, error) {
+	start := time.Now()
+	ids, err := db.Database.InsertBookmarks(ctx, bookmarks, progress)
+	db.observe("InsertBookmarks", start, err)
+	return ids, err
+}
+
+func (db *MetricsDatabase) UpsertBookmark(ctx context.Context, bookmark model.Bookmark) (int, bool, error) {
+	start := time.Now()
+	id, inserted, err := db.Database.UpsertBookmark(ctx, bookmark)
+	db.observe("UpsertBookmark", start, err)
+	return id, inserted, err
+}
+
+func (db *MetricsDatabase) GetBookmarks(ctx context.Context, withContent bool, opts ListOptions, ids ...int) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.GetBookmarks(ctx, withContent, opts, ids...)
+	db.observe("GetBookmarks", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) GetBookmark(ctx context.Context, id int, withContent bool) (model.Bookmark, bool, error) {
+	start := time.Now()
+	bookmark, found, err := db.Database.GetBookmark(ctx, id, withContent)
+	db.observe("GetBookmark", start, err)
+	return bookmark, found, err
+}
+
+func (db *MetricsDatabase) GetBookmarksModifiedSince(ctx context.Context, accountID int, t time.Time, withContent bool) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.GetBookmarksModifiedSince(ctx, accountID, t, withContent)
+	db.observe("GetBookmarksModifiedSince", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) GetUntaggedBookmarks(ctx context.Context, accountID int, withContent bool) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.GetUntaggedBookmarks(ctx, accountID, withContent)
+	db.observe("GetUntaggedBookmarks", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) GetTags(ctx context.Context, accountID int) ([]model.Tag, error) {
+	start := time.Now()
+	tags, err := db.Database.GetTags(ctx, accountID)
+	db.observe("GetTags", start, err)
+	return tags, err
+}
+
+func (db *MetricsDatabase) GetAuthors(ctx context.Context, accountID int) ([]string, error) {
+	start := time.Now()
+	authors, err := db.Database.GetAuthors(ctx, accountID)
+	db.observe("GetAuthors", start, err)
+	return authors, err
+}
+
+func (db *MetricsDatabase) GetDomains(ctx context.Context, accountID int) ([]model.DomainCount, error) {
+	start := time.Now()
+	domains, err := db.Database.GetDomains(ctx, accountID)
+	db.observe("GetDomains", start, err)
+	return domains, err
+}
+
+func (db *MetricsDatabase) BackfillDomains(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := db.Database.BackfillDomains(ctx)
+	db.observe("BackfillDomains", start, err)
+	return n, err
+}
+
+func (db *MetricsDatabase) GetTagsForSearch(ctx context.Context, accountID int, keyword string, tags ...string) ([]model.Tag, error) {
+	start := time.Now()
+	result, err := db.Database.GetTagsForSearch(ctx, accountID, keyword, tags...)
+	db.observe("GetTagsForSearch", start, err)
+	return result, err
+}
+
+func (db *MetricsDatabase) SearchTags(ctx context.Context, accountID int, prefix string, limit int) ([]model.Tag, error) {
+	start := time.Now()
+	tags, err := db.Database.SearchTags(ctx, accountID, prefix, limit)
+	db.observe("SearchTags", start, err)
+	return tags, err
+}
+
+func (db *MetricsDatabase) GetRelatedTags(ctx context.Context, accountID int, tagID int, limit int) ([]model.Tag, error) {
+	start := time.Now()
+	tags, err := db.Database.GetRelatedTags(ctx, accountID, tagID, limit)
+	db.observe("GetRelatedTags", start, err)
+	return tags, err
+}
+
+func (db *MetricsDatabase) GetTagsForBookmark(ctx context.Context, bookmarkID int) ([]model.Tag, error) {
+	start := time.Now()
+	tags, err := db.Database.GetTagsForBookmark(ctx, bookmarkID)
+	db.observe("GetTagsForBookmark", start, err)
+	return tags, err
+}
+
+func (db *MetricsDatabase) DeleteBookmarks(ctx context.Context, ids ...int) error {
+	start := time.Now()
+	err := db.Database.DeleteBookmarks(ctx, ids...)
+	db.observe("DeleteBookmarks", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) DeleteAllBookmarks(ctx context.Context) error {
+	start := time.Now()
+	err := db.Database.DeleteAllBookmarks(ctx)
+	db.observe("DeleteAllBookmarks", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) RestoreBookmarks(ctx context.Context, ids ...int) error {
+	start := time.Now()
+	err := db.Database.RestoreBookmarks(ctx, ids...)
+	db.observe("RestoreBookmarks", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) PurgeBookmarks(ctx context.Context, ids ...int) error {
+	start := time.Now()
+	err := db.Database.PurgeBookmarks(ctx, ids...)
+	db.observe("PurgeBookmarks", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) SearchBookmarks(ctx context.Context, opts SearchOptions) ([]model.Bookmark, int, error) {
+	start := time.Now()
+	bookmarks, count, err := db.Database.SearchBookmarks(ctx, opts)
+	db.observe("SearchBookmarks", start, err)
+	return bookmarks, count, err
+}
+
+func (db *MetricsDatabase) UpdateBookmarks(ctx context.Context, bookmarks ...model.Bookmark) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.UpdateBookmarks(ctx, bookmarks...)
+	db.observe("UpdateBookmarks", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) SaveArchive(ctx context.Context, bookmarkID int, data []byte, mime string) error {
+	start := time.Now()
+	err := db.Database.SaveArchive(ctx, bookmarkID, data, mime)
+	db.observe("SaveArchive", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) GetArchive(ctx context.Context, bookmarkID int) ([]byte, string, error) {
+	start := time.Now()
+	data, mimeType, err := db.Database.GetArchive(ctx, bookmarkID)
+	db.observe("GetArchive", start, err)
+	return data, mimeType, err
+}
+
+func (db *MetricsDatabase) SaveThumbnail(ctx context.Context, bookmarkID int, data []byte, mime string) error {
+	start := time.Now()
+	err := db.Database.SaveThumbnail(ctx, bookmarkID, data, mime)
+	db.observe("SaveThumbnail", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) GetThumbnail(ctx context.Context, bookmarkID int) ([]byte, string, error) {
+	start := time.Now()
+	data, mimeType, err := db.Database.GetThumbnail(ctx, bookmarkID)
+	db.observe("GetThumbnail", start, err)
+	return data, mimeType, err
+}
+
+func (db *MetricsDatabase) CreateAccount(ctx context.Context, username, password string, isAdmin bool) error {
+	start := time.Now()
+	err := db.Database.CreateAccount(ctx, username, password, isAdmin)
+	db.observe("CreateAccount", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) SetAccountAdmin(ctx context.Context, username string, admin bool) error {
+	start := time.Now()
+	err := db.Database.SetAccountAdmin(ctx, username, admin)
+	db.observe("SetAccountAdmin", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) GetAccount(ctx context.Context, username string) (model.Account, error) {
+	start := time.Now()
+	account, err := db.Database.GetAccount(ctx, username)
+	db.observe("GetAccount", start, err)
+	return account, err
+}
+
+func (db *MetricsDatabase) GetAccountByID(ctx context.Context, id int) (model.Account, error) {
+	start := time.Now()
+	account, err := db.Database.GetAccountByID(ctx, id)
+	db.observe("GetAccountByID", start, err)
+	return account, err
+}
+
+func (db *MetricsDatabase) UpdateAccountPassword(ctx context.Context, username, newPassword string) error {
+	start := time.Now()
+	err := db.Database.UpdateAccountPassword(ctx, username, newPassword)
+	db.observe("UpdateAccountPassword", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) VerifyAccount(ctx context.Context, username, password string) (model.Account, error) {
+	start := time.Now()
+	account, err := db.Database.VerifyAccount(ctx, username, password)
+	db.observe("VerifyAccount", start, err)
+	return account, err
+}
+
+func (db *MetricsDatabase) GetAccounts(ctx context.Context, keyword string, exactMatch bool) ([]model.Account, error) {
+	start := time.Now()
+	accounts, err := db.Database.GetAccounts(ctx, keyword, exactMatch)
+	db.observe("GetAccounts", start, err)
+	return accounts, err
+}
+
+func (db *MetricsDatabase) DeleteAccounts(ctx context.Context, usernames ...string) error {
+	start := time.Now()
+	err := db.Database.DeleteAccounts(ctx, usernames...)
+	db.observe("DeleteAccounts", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) DeleteAllAccounts(ctx context.Context) error {
+	start := time.Now()
+	err := db.Database.DeleteAllAccounts(ctx)
+	db.observe("DeleteAllAccounts", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) CreateAPIToken(ctx context.Context, accountID int, label string) (string, error) {
+	start := time.Now()
+	token, err := db.Database.CreateAPIToken(ctx, accountID, label)
+	db.observe("CreateAPIToken", start, err)
+	return token, err
+}
+
+func (db *MetricsDatabase) ResolveAPIToken(ctx context.Context, token string) (model.Account, error) {
+	start := time.Now()
+	account, err := db.Database.ResolveAPIToken(ctx, token)
+	db.observe("ResolveAPIToken", start, err)
+	return account, err
+}
+
+func (db *MetricsDatabase) GetAPITokens(ctx context.Context, accountID int) ([]model.APIToken, error) {
+	start := time.Now()
+	tokens, err := db.Database.GetAPITokens(ctx, accountID)
+	db.observe("GetAPITokens", start, err)
+	return tokens, err
+}
+
+func (db *MetricsDatabase) DeleteAPITokens(ctx context.Context, ids ...int) error {
+	start := time.Now()
+	err := db.Database.DeleteAPITokens(ctx, ids...)
+	db.observe("DeleteAPITokens", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) UpdateTag(ctx context.Context, id int, name, description string) error {
+	start := time.Now()
+	err := db.Database.UpdateTag(ctx, id, name, description)
+	db.observe("UpdateTag", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) RenameTag(ctx context.Context, oldName, newName string) error {
+	start := time.Now()
+	err := db.Database.RenameTag(ctx, oldName, newName)
+	db.observe("RenameTag", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) MergeTags(ctx context.Context, sourceIDs []int, targetID int) error {
+	start := time.Now()
+	err := db.Database.MergeTags(ctx, sourceIDs, targetID)
+	db.observe("MergeTags", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) DeleteTags(ctx context.Context, ids ...int) error {
+	start := time.Now()
+	err := db.Database.DeleteTags(ctx, ids...)
+	db.observe("DeleteTags", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) GetUnusedTags(ctx context.Context) ([]model.Tag, error) {
+	start := time.Now()
+	tags, err := db.Database.GetUnusedTags(ctx)
+	db.observe("GetUnusedTags", start, err)
+	return tags, err
+}
+
+func (db *MetricsDatabase) DeleteUnusedTags(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := db.Database.DeleteUnusedTags(ctx)
+	db.observe("DeleteUnusedTags", start, err)
+	return n, err
+}
+
+func (db *MetricsDatabase) RecomputeReadTimes(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := db.Database.RecomputeReadTimes(ctx)
+	db.observe("RecomputeReadTimes", start, err)
+	return n, err
+}
+
+func (db *MetricsDatabase) UpdateBookmarkTags(ctx context.Context, bookmarkID int, addTags []string, removeTags []string) error {
+	start := time.Now()
+	err := db.Database.UpdateBookmarkTags(ctx, bookmarkID, addTags, removeTags)
+	db.observe("UpdateBookmarkTags", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) AddTagToBookmarks(ctx context.Context, tagName string, bookmarkIDs []int) error {
+	start := time.Now()
+	err := db.Database.AddTagToBookmarks(ctx, tagName, bookmarkIDs)
+	db.observe("AddTagToBookmarks", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) MarkRead(ctx context.Context, ids []int, read bool) error {
+	start := time.Now()
+	err := db.Database.MarkRead(ctx, ids, read)
+	db.observe("MarkRead", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) MarkFavorite(ctx context.Context, ids []int, favorite bool) error {
+	start := time.Now()
+	err := db.Database.MarkFavorite(ctx, ids, favorite)
+	db.observe("MarkFavorite", start, err)
+	return err
+}
+
+func (db *MetricsDatabase) DeleteEmptyTags(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := db.Database.DeleteEmptyTags(ctx)
+	db.observe("DeleteEmptyTags", start, err)
+	return n, err
+}
+
+func (db *MetricsDatabase) FindBookmarksByURLPrefix(ctx context.Context, prefix string) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.FindBookmarksByURLPrefix(ctx, prefix)
+	db.observe("FindBookmarksByURLPrefix", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) FindDuplicateBookmarks(ctx context.Context, url string) ([]model.Bookmark, error) {
+	start := time.Now()
+	bookmarks, err := db.Database.FindDuplicateBookmarks(ctx, url)
+	db.observe("FindDuplicateBookmarks", start, err)
+	return bookmarks, err
+}
+
+func (db *MetricsDatabase) GetBookmarkID(ctx context.Context, url string) int {
+	start := time.Now()
+	id := db.Database.GetBookmarkID(ctx, url)
+	db.observe("GetBookmarkID", start, nil)
+	return id
+}
+
+func (db *MetricsDatabase) GetStats(ctx context.Context, accountID int) (model.Stats, error) {
+	start := time.Now()
+	stats, err := db.Database.GetStats(ctx, accountID)
+	db.observe("GetStats", start, err)
+	return stats, err
+}
+
+func (db *MetricsDatabase) GetMaxModified(ctx context.Context, accountID int) (time.Time, int, error) {
+	start := time.Now()
+	t, n, err := db.Database.GetMaxModified(ctx, accountID)
+	db.observe("GetMaxModified", start, err)
+	return t, n, err
+}
+
+func (db *MetricsDatabase) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := db.Database.Ping(ctx)
+	db.observe("Ping", start, err)
+	return err
+}
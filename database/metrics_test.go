@@ -0,0 +1,108 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	db := NewMetricsDatabase(openTestDatabase(t))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.GetBookmarks(ctx, false, ListOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.GetAccountByID(ctx, 999); err == nil {
+		t.Fatal("got account that doesn't exist")
+	}
+
+	var buf bytes.Buffer
+	if err := db.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	for _, line := range []string{
+		`# TYPE shiori_db_queries_total counter`,
+		`shiori_db_queries_total{method="GetBookmarks"} 2`,
+		`shiori_db_errors_total{method="GetBookmarks"} 0`,
+		`shiori_db_errors_total{method="GetAccountByID"} 1`,
+		`# TYPE shiori_db_query_duration_seconds histogram`,
+		`shiori_db_query_duration_seconds_bucket{method="GetBookmarks",le="+Inf"} 2`,
+		`shiori_db_query_duration_seconds_count{method="GetAccountByID"} 1`,
+		`# TYPE shiori_db_open_connections gauge`,
+		`shiori_db_in_use_connections 0`,
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("line %q is missing from metrics:\n%s", line, output)
+		}
+	}
+
+	// Every sample must be parsable by Prometheus
+	sample := regexp.MustCompile(`^shiori_db_[a-z_]+(\{method="[A-Za-z]+"(,le="[0-9.e+Inf-]+")?\})? [0-9.e+-]+$`)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if !strings.HasPrefix(line, "# ") && !sample.MatchString(line) {
+			t.Errorf("sample %q is not valid", line)
+		}
+	}
+}
+
+// recordingLogger keeps the messages logged by MetricsDatabase, by level.
+type recordingLogger struct {
+	debug, warn []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func TestSlowCallsAreLogged(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		slow      bool
+	}{
+		{"fast", time.Hour, false},
+		{"slow", time.Nanosecond, true},
+	}
+
+	for _, tt := range tests {
+		logger := &recordingLogger{}
+		db := NewMetricsDatabase(openTestDatabase(t))
+		db.SetLogger(logger, tt.threshold)
+
+		db.GetBookmarks(ctx, false, ListOptions{})
+		db.GetAccountByID(ctx, 999)
+
+		logged := logger.debug
+		if tt.slow {
+			logged = logger.warn
+		}
+		if len(logger.debug)+len(logger.warn) != 2 || len(logged) != 2 {
+			t.Fatalf("%s: got debug %q and warn %q", tt.name, logger.debug, logger.warn)
+		}
+
+		if !strings.HasPrefix(logged[0], "database: GetBookmarks took ") || strings.Contains(logged[0], "failed") {
+			t.Errorf("%s: got %q for successful call", tt.name, logged[0])
+		}
+		if !strings.Contains(logged[1], "GetAccountByID") || !strings.Contains(logged[1], "failed: "+ErrAccountNotFound.Error()) {
+			t.Errorf("%s: got %q for failed call", tt.name, logged[1])
+		}
+		if tt.slow != strings.Contains(logged[0], "slower than") {
+			t.Errorf("%s: got %q", tt.name, logged[0])
+		}
+	}
+}
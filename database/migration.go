@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/go-xorm/xorm"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// migration is a change to the database that Sync2 can't do by itself, e.g. creating
+// the search index or filling a new column from the existing data.
+type migration struct {
+	version     int
+	description string
+	migrate     func(session *xorm.Session, dbType string) error
+}
+
+// migrations are applied in order of their version, and each of them only once.
+// New migrations must be added at the end with a higher version,
+// and the ones that already released must never be changed.
+var migrations = []migration{
+	{1, "create full text search index", createSearchIndex},
+	{2, "fill domain of bookmarks", func(session *xorm.Session, dbType string) error {
+		_, err := backfillDomains(session)
+		return err
+	}},
+	{3, "fill columns added to existing bookmarks", fillAddedBookmarkColumns},
+}
+
+// migrate applies the migrations that haven't been applied to database yet.
+// Each migration is applied in its own transaction together with its record in
+// schema_migrations, so it's either applied and recorded, or neither. MySQL commits
+// schema changes implicitly, so on MySQL a migration must be safe to run again.
+func migrate(db *xorm.Engine, dbType string) error {
+	if err := db.Sync2(new(model.SchemaMigration)); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	appliedVersions := make([]int, 0)
+	err := db.Table("schema_migrations").Cols("version").Find(&appliedVersions)
+	if err != nil {
+		return fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	applied := make(map[int]struct{}, len(appliedVersions))
+	for _, version := range appliedVersions {
+		applied[version] = struct{}{}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(db, dbType, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies m and records it in one transaction.
+func applyMigration(db *xorm.Engine, dbType string, m migration) error {
+	session := db.NewSession()
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	if err := m.migrate(session, dbType); err != nil {
+		return err
+	}
+
+	_, err := session.Insert(&model.SchemaMigration{Version: m.version, Description: m.description})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// createSearchIndex creates the full text search index of bookmarks, on the databases
+// that support it. Other databases search with LIKE, so they don't need any index.
+func createSearchIndex(session *xorm.Session, dbType string) error {
+	switch dbType {
+	case "postgres":
+		_, err := session.Exec("CREATE INDEX IF NOT EXISTS bookmark_search_idx ON bookmark USING GIN (" + pgSearchVector + ")")
+		return err
+	case "sqlite3":
+		return createSQLiteSearchIndex(session)
+	}
+	return nil
+}
+
+// addedBookmarkColumns are the columns added to bookmark table after its first release,
+// with the value used for the existing bookmarks.
+var addedBookmarkColumns = []struct {
+	name  string
+	value interface{}
+}{
+	{"is_read", false},
+	{"favorite", false},
+	{"note", ""},
+	{"domain", ""},
+}
+
+// fillAddedBookmarkColumns makes the bookmarks saved with the old schema valid in the new one.
+// Sync2 adds the missing columns with ALTER TABLE, using the default of each column, but
+// databases created by older versions may have them without default, so their rows are NULL.
+// Bookmarks that don't have creation time get their modified time, which is the closest
+// known value, and their domain is filled from URL.
+func fillAddedBookmarkColumns(session *xorm.Session, dbType string) error {
+	for _, column := range addedBookmarkColumns {
+		_, err := session.Exec("UPDATE bookmark SET "+column.name+" = ? WHERE "+column.name+" IS NULL", column.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := session.Exec("UPDATE bookmark SET created = modified WHERE created IS NULL")
+	if err != nil {
+		return err
+	}
+
+	_, err = backfillDomains(session)
+	return err
+}
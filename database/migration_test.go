@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	fp "path/filepath"
+	"testing"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// appliedMigrations returns the records of migrations applied to db.
+func appliedMigrations(t *testing.T, db *XormDatabase) []model.SchemaMigration {
+	t.Helper()
+
+	records := make([]model.SchemaMigration, 0)
+	if err := db.Asc("version").Find(&records); err != nil {
+		t.Fatalf("failed to read applied migrations: %v", err)
+	}
+	return records
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openTestDatabase(t)
+
+	records := appliedMigrations(t, db)
+	if len(records) != len(migrations) {
+		t.Fatalf("got %d applied migrations, want %d", len(records), len(migrations))
+	}
+	for i, m := range migrations {
+		if records[i].Version != m.version {
+			t.Errorf("migration #%d has version %d, want %d", i, records[i].Version, m.version)
+		}
+	}
+
+	exist, err := db.IsTableExist("bookmark_fts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exist {
+		t.Errorf("search index isn't created")
+	}
+}
+
+func TestMigrateAgainIsNoop(t *testing.T) {
+	path := fp.Join(t.TempDir(), "shiori.db")
+	ctx := context.Background()
+
+	first, err := OpenSQLiteDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := model.Bookmark{URL: "https://example.com", Title: "Example", Content: "searchable"}
+	if err := first.InsertBookmark(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+	firstRecords := appliedMigrations(t, first.(*XormDatabase))
+	first.Close()
+
+	second, err := OpenSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open migrated database: %v", err)
+	}
+	defer second.Close()
+
+	db := second.(*XormDatabase)
+	if err := migrate(db.Engine, db.dbType); err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+
+	records := appliedMigrations(t, db)
+	if len(records) != len(firstRecords) {
+		t.Fatalf("got %d applied migrations, want %d", len(records), len(firstRecords))
+	}
+	for i := range records {
+		if !records[i].Applied.Equal(firstRecords[i].Applied) {
+			t.Errorf("migration %d is applied again", records[i].Version)
+		}
+	}
+
+	bookmarks, _, err := db.SearchBookmarks(ctx, SearchOptions{Keyword: "searchable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 1 {
+		t.Errorf("got %d bookmarks from search index, want 1", len(bookmarks))
+	}
+}
+
+func TestSQLiteUsesOneConnection(t *testing.T) {
+	db := openTestDatabase(t)
+	if n := db.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("got %d max open connections, want 1", n)
+	}
+}
+
+// oldBookmarkSchema is the bookmark table of the first release, before it had read, favorite,
+// created, domain and account columns. Note was added later without any default.
+const oldBookmarkSchema = `CREATE TABLE bookmark (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT,
+	title TEXT NOT NULL,
+	image_url TEXT NOT NULL,
+	excerpt TEXT NOT NULL,
+	author TEXT NOT NULL,
+	min_read_time INTEGER DEFAULT 0,
+	max_read_time INTEGER DEFAULT 0,
+	modified DATETIME,
+	content TEXT,
+	html TEXT,
+	has_content INTEGER,
+	note TEXT
+)`
+
+func TestMigrateOldSchema(t *testing.T) {
+	path := fp.Join(t.TempDir(), "shiori.db")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(oldBookmarkSchema); err != nil {
+		t.Fatalf("failed to create old schema: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO bookmark (url, title, image_url, excerpt, author, modified, content, has_content)
+		VALUES ('https://www.example.com/article', 'Old article', '', 'Excerpt', 'Author', '2019-05-06 07:08:09', 'old content', 1)`)
+	if err != nil {
+		t.Fatalf("failed to save old bookmark: %v", err)
+	}
+	old.Close()
+
+	db, err := OpenSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to migrate old database: %v", err)
+	}
+	defer db.Close()
+
+	book, found, err := db.GetBookmark(context.Background(), 1, true)
+	if err != nil || !found {
+		t.Fatalf("old bookmark isn't found after migration, err %v", err)
+	}
+
+	if book.Title != "Old article" || book.Excerpt != "Excerpt" || book.Content != "old content" {
+		t.Errorf("bookmark data is changed by migration: %+v", book)
+	}
+	if book.Read || book.Favorite || book.Note != "" || book.AccountID != 0 {
+		t.Errorf("got read %v, favorite %v, note %q and account %d, want defaults",
+			book.Read, book.Favorite, book.Note, book.AccountID)
+	}
+	if book.Domain != "www.example.com" {
+		t.Errorf("got domain %q, want www.example.com", book.Domain)
+	}
+
+	if modified := book.Modified.Format("2006-01-02 15:04:05"); modified != "2019-05-06 07:08:09" {
+		t.Errorf("got modified %s, want 2019-05-06 07:08:09", modified)
+	}
+	if !book.Created.Equal(book.Modified) {
+		t.Errorf("got created %v, want modified %v", book.Created, book.Modified)
+	}
+
+	var nulls int
+	err = db.(*XormDatabase).DB().QueryRow(`SELECT COUNT(*) FROM bookmark
+		WHERE is_read IS NULL OR favorite IS NULL OR note IS NULL OR created IS NULL`).Scan(&nulls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nulls != 0 {
+		t.Errorf("%d bookmarks still have NULL in added columns", nulls)
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+// ProgressFunc is called with the number of items done so far and the total number of items,
+// e.g. to show the progress of importing bookmarks.
+type ProgressFunc func(done, total int)
+
+// progressReporter calls ProgressFunc from its own goroutine, so a slow callback never keeps
+// the transaction that reports the progress open for longer. If the callback can't keep up,
+// only the latest count is passed to it, so it's always called with increasing counts.
+type progressReporter struct {
+	progress ProgressFunc
+	total    int
+	counts   chan int
+	stopped  chan struct{}
+}
+
+// newProgressReporter starts reporting to progress. If progress is nil, nothing is reported.
+func newProgressReporter(progress ProgressFunc, total int) *progressReporter {
+	r := &progressReporter{progress: progress, total: total}
+	if progress == nil {
+		return r
+	}
+
+	r.counts = make(chan int, 1)
+	r.stopped = make(chan struct{})
+	go func() {
+		for done := range r.counts {
+			r.progress(done, r.total)
+		}
+		close(r.stopped)
+	}()
+	return r
+}
+
+// report queues done to be reported, replacing the one that not reported yet. It never blocks,
+// since the reporting goroutine only receives from the channel.
+func (r *progressReporter) report(done int) {
+	if r.progress == nil {
+		return
+	}
+
+	select {
+	case <-r.counts:
+	default:
+	}
+	r.counts <- done
+}
+
+// stop waits until the queued count is reported. The reporter can't be used after that.
+func (r *progressReporter) stop() {
+	if r.progress == nil {
+		return
+	}
+
+	close(r.counts)
+	<-r.stopped
+}
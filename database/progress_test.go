@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// checkProgress fails the test if counts don't increase up to total.
+func checkProgress(t *testing.T, counts []int, total int) {
+	t.Helper()
+
+	if len(counts) == 0 || counts[len(counts)-1] != total {
+		t.Fatalf("got progress %v, want it to end with %d", counts, total)
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] <= counts[i-1] {
+			t.Fatalf("got progress %v, want increasing counts", counts)
+		}
+	}
+}
+
+func TestProgressReporterWithSlowCallback(t *testing.T) {
+	var counts []int
+	reporter := newProgressReporter(func(done, total int) {
+		if total != 50 {
+			t.Errorf("got total %d, want 50", total)
+		}
+		counts = append(counts, done)
+		time.Sleep(time.Millisecond)
+	}, 50)
+
+	start := time.Now()
+	for i := 1; i <= 50; i++ {
+		reporter.report(i)
+	}
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("reporting took %v, want it to not wait for the callback", elapsed)
+	}
+	reporter.stop()
+
+	// The slow callback skips some counts, but never goes back
+	checkProgress(t, counts, 50)
+}
+
+func TestProgressReporterWithoutCallback(t *testing.T) {
+	reporter := newProgressReporter(nil, 3)
+	for i := 1; i <= 3; i++ {
+		reporter.report(i)
+	}
+	reporter.stop()
+}
+
+func TestInsertBookmarksProgress(t *testing.T) {
+	db := openTestDatabase(t)
+
+	bookmarks := make([]model.Bookmark, 20)
+	for i := range bookmarks {
+		bookmarks[i] = model.Bookmark{URL: fmt.Sprintf("https://example.com/%d", i), Title: "page"}
+	}
+
+	var counts []int
+	ids, err := db.InsertBookmarks(context.Background(), bookmarks, func(done, total int) {
+		counts = append(counts, done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(bookmarks) {
+		t.Fatalf("got %d ids, want %d", len(ids), len(bookmarks))
+	}
+
+	checkProgress(t, counts, len(bookmarks))
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"math"
+	"strings"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// MinWordsPerMinute and MaxWordsPerMinute are the reading speed of slow and fast readers,
+// used by EstimateReadTime.
+var (
+	MinWordsPerMinute = 200
+	MaxWordsPerMinute = 260
+)
+
+// EstimateReadTime returns the minimum and maximum minutes needed to read content,
+// based on its word count.
+func EstimateReadTime(content string) (minReadTime, maxReadTime int) {
+	nWords := float64(len(strings.Fields(content)))
+	minReadTime = int(math.Floor(nWords/float64(MaxWordsPerMinute) + 0.5))
+	maxReadTime = int(math.Floor(nWords/float64(MinWordsPerMinute) + 0.5))
+	return minReadTime, maxReadTime
+}
+
+// fillReadTime estimates reading time of bookmark, unless it's already set.
+func fillReadTime(bookmark *model.Bookmark) {
+	if bookmark.MinReadTime != 0 || bookmark.MaxReadTime != 0 || bookmark.Content == "" {
+		return
+	}
+
+	bookmark.MinReadTime, bookmark.MaxReadTime = EstimateReadTime(bookmark.Content)
+}
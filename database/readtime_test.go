@@ -0,0 +1,28 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateReadTime(t *testing.T) {
+	tests := []struct {
+		nWords int
+		min    int
+		max    int
+	}{
+		{0, 0, 0},
+		{100, 0, 1},
+		{520, 2, 3},
+		{1000, 4, 5},
+		{2600, 10, 13},
+	}
+
+	for _, tt := range tests {
+		content := strings.TrimSpace(strings.Repeat("word \n", tt.nWords))
+		minReadTime, maxReadTime := EstimateReadTime(content)
+		if minReadTime != tt.min || maxReadTime != tt.max {
+			t.Errorf("%d words: got %d-%d minutes, want %d-%d", tt.nWords, minReadTime, maxReadTime, tt.min, tt.max)
+		}
+	}
+}
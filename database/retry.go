@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// MaxRetries is how many times a write is run again when it fails because of
+// serialization failure or deadlock with another transaction.
+var MaxRetries = 3
+
+// retryBackoff is the wait before the first retry. It's doubled on every retry.
+const retryBackoff = 50 * time.Millisecond
+
+// withRetry runs fn, and runs it again with backoff while it fails with retryable error.
+// fn must be safe to re-run, i.e. it runs all of its writes in one transaction.
+func withRetry(ctx context.Context, fn func() error) error {
+	backoff := retryBackoff
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || retry >= MaxRetries || !isRetryableError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// isRetryableError returns whether err is caused by a concurrent transaction,
+// so running the same transaction again may succeed.
+func isRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure and deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{&mysql.MySQLError{Number: 1213}, true},
+		{&mysql.MySQLError{Number: 1205}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{fmt.Errorf("failed to save tags: %w", &pq.Error{Code: "40001"}), true},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryableError(tt.err); got != tt.want {
+			t.Errorf("%#v: got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	defer func(n int) { MaxRetries = n }(MaxRetries)
+	MaxRetries = 2
+
+	serializationFailure := &pq.Error{Code: "40001"}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		failures int
+		err      error
+		calls    int
+		wantErr  bool
+	}{
+		{"succeeds at once", context.Background(), 0, serializationFailure, 1, false},
+		{"succeeds after retry", context.Background(), 2, serializationFailure, 3, false},
+		{"fails every retry", context.Background(), 10, serializationFailure, 3, true},
+		{"other error", context.Background(), 10, errors.New("syntax error"), 1, true},
+		{"context is done", canceled, 10, serializationFailure, 1, true},
+	}
+
+	for _, tt := range tests {
+		calls := 0
+		err := withRetry(tt.ctx, func() error {
+			calls++
+			if calls <= tt.failures {
+				return tt.err
+			}
+			return nil
+		})
+
+		if calls != tt.calls {
+			t.Errorf("%s: got %d calls, want %d", tt.name, calls, tt.calls)
+		}
+		if tt.wantErr && err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
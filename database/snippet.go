@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// maxSnippetWords is the maximum number of words in the snippet of a search result.
+const maxSnippetWords = 35
+
+// fillSnippets fills the snippet of bookmarks with the part of their content that matched
+// keyword, where the matched words are wrapped in <b>. On PostgreSQL the snippet is made
+// by ts_headline, so it follows the full text search. On other databases it's made from
+// the first word that contains the keyword.
+func (db *XormDatabase) fillSnippets(ctx context.Context, bookmarks []model.Bookmark, keyword string, prefix bool) error {
+	if db.dbType != "postgres" {
+		for i := range bookmarks {
+			bookmarks[i].Snippet = makeSnippet(bookmarks[i].Content, keyword)
+		}
+		return nil
+	}
+
+	tsQuery := "plainto_tsquery('english', ?)"
+	if prefix {
+		if keyword = pgPrefixQuery(keyword); keyword == "" {
+			return nil
+		}
+		tsQuery = "to_tsquery('english', ?)"
+	}
+
+	options := fmt.Sprintf("StartSel=<b>, StopSel=</b>, MaxWords=%d, MinWords=%d", maxSnippetWords, maxSnippetWords/2)
+	bookmarkIndex := make(map[int]int, len(bookmarks))
+	ids := make([]interface{}, 0, len(bookmarks))
+	for i := range bookmarks {
+		bookmarkIndex[bookmarks[i].ID] = i
+		ids = append(ids, bookmarks[i].ID)
+	}
+
+	for start := 0; start < len(ids); start += 500 {
+		end := int(math.Min(float64(start+500), float64(len(ids))))
+		chunk := ids[start:end]
+
+		query := "SELECT id, ts_headline('english', coalesce(content, ''), " + tsQuery + ", ?) AS snippet " +
+			"FROM bookmark WHERE id IN (?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+		args := append([]interface{}{keyword, options}, chunk...)
+
+		rows := []struct {
+			ID      int    `xorm:"id"`
+			Snippet string `xorm:"snippet"`
+		}{}
+		if err := db.Context(ctx).SQL(query, args...).Find(&rows); err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			bookmarks[bookmarkIndex[row.ID]].Snippet = escapeSnippet(row.Snippet)
+		}
+	}
+
+	return nil
+}
+
+// makeSnippet returns the words of content around the first word that contains keyword,
+// limited to maxSnippetWords. Every word that contains keyword is wrapped in <b>.
+// If none matches, e.g. because the keyword is found in URL, the beginning of content is used.
+func makeSnippet(content, keyword string) string {
+	words := strings.Fields(content)
+	terms := strings.Fields(strings.ToLower(keyword))
+	if len(words) == 0 || len(terms) == 0 {
+		return ""
+	}
+
+	matches := func(word string) bool {
+		word = strings.ToLower(word)
+		for _, term := range terms {
+			if strings.Contains(word, term) {
+				return true
+			}
+		}
+		return false
+	}
+
+	first := 0
+	for i, word := range words {
+		if matches(word) {
+			first = i
+			break
+		}
+	}
+
+	// Show a few words before the match, so it's read in context
+	start := first - maxSnippetWords/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxSnippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	parts := make([]string, 0, end-start)
+	for _, word := range words[start:end] {
+		if matches(word) {
+			parts = append(parts, "<b>"+html.EscapeString(word)+"</b>")
+		} else {
+			parts = append(parts, html.EscapeString(word))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// escapeSnippet escapes the HTML in snippet made by ts_headline,
+// except the <b> that wraps the matched words.
+func escapeSnippet(snippet string) string {
+	snippet = html.EscapeString(strings.Join(strings.Fields(snippet), " "))
+	snippet = strings.Replace(snippet, "&lt;b&gt;", "<b>", -1)
+	return strings.Replace(snippet, "&lt;/b&gt;", "</b>", -1)
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestMakeSnippet(t *testing.T) {
+	tests := []struct {
+		content string
+		keyword string
+		want    string
+	}{
+		{"Go is fun", "go", "<b>Go</b> is fun"},
+		{"Learn golang and Go", "GO", "Learn <b>golang</b> and <b>Go</b>"},
+		{"Use <script> in Go", "go", "Use &lt;script&gt; in <b>Go</b>"},
+		{"Nothing matches here", "rust", "Nothing matches here"},
+		{"Tomatoes and basil", "basil tomato", "<b>Tomatoes</b> and <b>basil</b>"},
+		{"", "go", ""},
+		{"Go is fun", "  ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := makeSnippet(tt.content, tt.keyword); got != tt.want {
+			t.Errorf("makeSnippet(%q, %q) = %q, want %q", tt.content, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestMakeSnippetOfLongContent(t *testing.T) {
+	words := make([]string, 100)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	words[60] = "needle"
+
+	snippet := strings.Fields(makeSnippet(strings.Join(words, " "), "needle"))
+	if len(snippet) != maxSnippetWords {
+		t.Fatalf("got %d words, want %d", len(snippet), maxSnippetWords)
+	}
+	if snippet[0] != fmt.Sprintf("w%d", 60-maxSnippetWords/3) {
+		t.Errorf("snippet starts with %s, want a few words before the match", snippet[0])
+	}
+	if snippet[maxSnippetWords/3] != "<b>needle</b>" {
+		t.Errorf("got snippet %v, want needle highlighted", snippet)
+	}
+}
+
+func TestEscapeSnippet(t *testing.T) {
+	got := escapeSnippet("a <b>match</b>\n in <i>text</i> & more")
+	want := "a <b>match</b> in &lt;i&gt;text&lt;/i&gt; &amp; more"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+	ctx := context.Background()
+	for dbType, db := range openTestDatabases(t) {
+		domain := fmt.Sprintf("snippet%d.example.com", time.Now().UnixNano())
+		book := model.Bookmark{
+			URL:     "https://" + domain + "/compost",
+			Title:   "Garden",
+			Content: "Good compost needs green and brown waste.",
+		}
+		if err := db.InsertBookmark(ctx, &book); err != nil {
+			t.Fatalf("%s: %v", dbType, err)
+		}
+		t.Cleanup(func() { db.PurgeBookmarks(ctx, book.ID) })
+
+		for _, withSnippet := range []bool{false, true} {
+			bookmarks, _, err := db.SearchBookmarks(ctx, SearchOptions{Keyword: "compost", Domain: domain, Snippet: withSnippet})
+			if err != nil {
+				t.Fatalf("%s: %v", dbType, err)
+			}
+			if len(bookmarks) != 1 {
+				t.Fatalf("%s: got %d bookmarks, want 1", dbType, len(bookmarks))
+			}
+
+			snippet := bookmarks[0].Snippet
+			if withSnippet && !strings.Contains(snippet, "<b>compost</b>") {
+				t.Errorf("%s: got snippet %q, want compost highlighted", dbType, snippet)
+			} else if !withSnippet && snippet != "" {
+				t.Errorf("%s: got snippet %q without asking for it", dbType, snippet)
+			}
+		}
+	}
+}
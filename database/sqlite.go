@@ -0,0 +1,83 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/go-xorm/xorm"
+)
+
+// sqliteSearchStatements keeps bookmark_fts, the FTS4 index of bookmark's title and content,
+// in sync with the bookmark table. bookmark_fts is an external content table,
+// so it only stores the index while the text itself stays in bookmark.
+var sqliteSearchStatements = []string{
+	`CREATE TRIGGER IF NOT EXISTS bookmark_fts_bu BEFORE UPDATE ON bookmark BEGIN
+		DELETE FROM bookmark_fts WHERE docid = old.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmark_fts_bd BEFORE DELETE ON bookmark BEGIN
+		DELETE FROM bookmark_fts WHERE docid = old.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmark_fts_au AFTER UPDATE ON bookmark BEGIN
+		INSERT INTO bookmark_fts (docid, title, content) VALUES (new.id, new.title, new.content);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmark_fts_ai AFTER INSERT ON bookmark BEGIN
+		INSERT INTO bookmark_fts (docid, title, content) VALUES (new.id, new.title, new.content);
+	END`,
+}
+
+// OpenSQLiteDatabase creates and open connection to SQLite3 database in the specified path.
+func OpenSQLiteDatabase(path string) (Database, error) {
+	return OpenXormDatabase(path, "sqlite3", SQLitePoolConfig())
+}
+
+// SQLitePoolConfig returns the pool configuration used for SQLite when none is specified.
+// SQLite only allows one writer at a time, so all queries share one connection and wait for
+// each other, instead of failing with "database is locked" when they write concurrently.
+func SQLitePoolConfig() PoolConfig {
+	pool := DefaultPoolConfig()
+	pool.MaxOpenConns = 1
+	pool.MaxIdleConns = 1
+	return pool
+}
+
+// createSQLiteSearchIndex creates the full text search table and its triggers.
+// Existing bookmarks are indexed when the table is created for the first time.
+func createSQLiteSearchIndex(session *xorm.Session) error {
+	exist, err := session.IsTableExist("bookmark_fts")
+	if err != nil {
+		return err
+	}
+
+	if !exist {
+		_, err = session.Exec("CREATE VIRTUAL TABLE bookmark_fts USING fts4(content='bookmark', title, content)")
+		if err != nil {
+			return err
+		}
+
+		_, err = session.Exec("INSERT INTO bookmark_fts (bookmark_fts) VALUES ('rebuild')")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range sqliteSearchStatements {
+		if _, err = session.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteMatchQuery converts the keyword into FTS query where each word is quoted,
+// so characters that have special meaning in FTS syntax are matched literally.
+// If prefix is true, each word also matches the words that start with it.
+func sqliteMatchQuery(keyword string, prefix bool) string {
+	words := strings.Fields(strings.Replace(keyword, `"`, " ", -1))
+	for i, word := range words {
+		if prefix {
+			word += "*"
+		}
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+// Max length in characters of the bookmark fields. Longer values are truncated with
+// an ellipsis when the bookmark is saved. Zero means no limit.
+var (
+	MaxTitleLength   = 512
+	MaxExcerptLength = 2048
+	MaxAuthorLength  = 256
+)
+
+// truncateFields truncates title, excerpt and author of bookmark that exceed their max length.
+func truncateFields(bookmark *model.Bookmark) {
+	bookmark.Title = truncate(bookmark.Title, MaxTitleLength)
+	bookmark.Excerpt = truncate(bookmark.Excerpt, MaxExcerptLength)
+	bookmark.Author = truncate(bookmark.Author, MaxAuthorLength)
+}
+
+// truncate returns s cut to at most maxLength characters, including the ellipsis that
+// marks it's truncated. It never cuts in the middle of a multi-byte character.
+func truncate(s string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:maxLength-1])) + "…"
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s         string
+		maxLength int
+		want      string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"one word too many", 10, "one word…"},
+		{"日本語のタイトルです", 5, "日本語の…"},
+		{"emoji 😀😀😀", 8, "emoji 😀…"},
+		{"no limit at all", 0, "no limit at all"},
+	}
+
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.maxLength)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d): got %q, want %q", tt.s, tt.maxLength, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d): %q is not valid UTF-8", tt.s, tt.maxLength, got)
+		}
+	}
+}
+
+func TestInsertBookmarkTruncatesFields(t *testing.T) {
+	db := openTestDatabase(t)
+
+	book := model.Bookmark{
+		URL:     "https://example.com",
+		Title:   strings.Repeat("é", MaxTitleLength+10),
+		Excerpt: strings.Repeat("x", MaxExcerptLength),
+		Author:  strings.Repeat("ü", MaxAuthorLength+1),
+	}
+	if err := db.InsertBookmark(context.Background(), &book); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _, err := db.GetBookmark(context.Background(), book.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := utf8.RuneCountInString(saved.Title); n != MaxTitleLength || !strings.HasSuffix(saved.Title, "…") {
+		t.Errorf("got title of %d characters, want %d ending with ellipsis", n, MaxTitleLength)
+	}
+	if saved.Excerpt != book.Excerpt || strings.HasSuffix(saved.Excerpt, "…") {
+		t.Error("excerpt within the limit is truncated")
+	}
+	if n := utf8.RuneCountInString(saved.Author); n != MaxAuthorLength {
+		t.Errorf("got author of %d characters, want %d", n, MaxAuthorLength)
+	}
+}
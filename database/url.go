@@ -0,0 +1,117 @@
+package database
+
+import (
+	"fmt"
+	nurl "net/url"
+	"strings"
+)
+
+// AllowAnyURLScheme allows saving bookmarks with URL of any scheme, e.g. ftp or file,
+// instead of only http and https. URL with javascript scheme is never allowed.
+var AllowAnyURLScheme = false
+
+// TrackingParams are query parameters that only used for tracking visitors,
+// so they don't change the page that URL points to. They are removed by NormalizeURL.
+// A trailing * matches any parameter with that prefix.
+var TrackingParams = []string{"utm_*", "fbclid", "gclid", "dclid", "msclkid", "mc_cid", "mc_eid"}
+
+// defaultPorts are ports that can be omitted from URL with the matching scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeURL returns url without fragment, default port and tracking parameters.
+// Fragments that route single page apps, e.g. #/page or #!page, are kept.
+// The scheme and host are converted to lower case and the remaining query parameters
+// are sorted, so two URLs that point to the same page have the same normalized form.
+// If url can't be parsed, it's returned as it is.
+func NormalizeURL(url string) string {
+	parsedURL, err := nurl.Parse(strings.TrimSpace(url))
+	if err != nil {
+		return url
+	}
+
+	ClearFragment(parsedURL)
+	parsedURL.Scheme = strings.ToLower(parsedURL.Scheme)
+	parsedURL.Host = strings.ToLower(parsedURL.Host)
+	if port := parsedURL.Port(); port != "" && port == defaultPorts[parsedURL.Scheme] {
+		parsedURL.Host = strings.TrimSuffix(parsedURL.Host, ":"+port)
+	}
+
+	query := parsedURL.Query()
+	for key := range query {
+		if isTrackingParam(key) {
+			query.Del(key)
+		}
+	}
+	parsedURL.RawQuery = query.Encode()
+
+	return parsedURL.String()
+}
+
+// ClearFragment removes fragment of url, since it only points to a part of the page.
+// Single page apps route with fragments like #/page or #!page though, those point
+// to another page so they're kept.
+func ClearFragment(url *nurl.URL) {
+	if !strings.HasPrefix(url.Fragment, "/") && !strings.HasPrefix(url.Fragment, "!") {
+		url.Fragment = ""
+	}
+}
+
+// ValidateURL returns an error if url can't be saved as bookmark, i.e. it's not an absolute
+// http or https URL with host, unless AllowAnyURLScheme is set. The error wraps ErrInvalidBookmark.
+func ValidateURL(url string) error {
+	if strings.TrimSpace(url) == "" {
+		return fmt.Errorf("%w: URL must not be empty", ErrInvalidBookmark)
+	}
+
+	parsedURL, err := nurl.Parse(strings.TrimSpace(url))
+	if err != nil {
+		return fmt.Errorf("%w: URL %s is not valid: %v", ErrInvalidBookmark, url, err)
+	}
+
+	scheme := strings.ToLower(parsedURL.Scheme)
+	switch {
+	case scheme == "":
+		return fmt.Errorf("%w: URL %s doesn't have scheme, e.g. https://", ErrInvalidBookmark, url)
+	case scheme == "javascript":
+		return fmt.Errorf("%w: URL %s is a script, not a page", ErrInvalidBookmark, url)
+	case AllowAnyURLScheme:
+		return nil
+	case scheme != "http" && scheme != "https":
+		return fmt.Errorf("%w: URL %s must use http or https scheme", ErrInvalidBookmark, url)
+	case parsedURL.Host == "":
+		return fmt.Errorf("%w: URL %s doesn't have host", ErrInvalidBookmark, url)
+	}
+
+	return nil
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	for _, param := range TrackingParams {
+		if strings.HasSuffix(param, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(param, "*")) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClearUTMParams removes the utm_ query parameters of url, which only tell
+// where the visitor came from.
+func ClearUTMParams(url *nurl.URL) {
+	newQuery := nurl.Values{}
+	for key, value := range url.Query() {
+		if !strings.HasPrefix(key, "utm_") {
+			newQuery[key] = value
+		}
+	}
+
+	url.RawQuery = newQuery.Encode()
+}
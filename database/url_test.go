@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	nurl "net/url"
+	"strings"
+	"testing"
+
+	"src.techknowlogick.com/shiori/model"
+)
+
+func TestClearUTMParams(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/?utm_source=feed&utm_medium=rss", "https://example.com/"},
+		{"https://example.com/?id=1&utm_campaign=news", "https://example.com/?id=1"},
+		{"https://example.com/?id=1", "https://example.com/?id=1"},
+	}
+
+	for _, tt := range tests {
+		parsedURL, err := nurl.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ClearUTMParams(parsedURL)
+		if got := parsedURL.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURLFragments(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/page#section", "https://example.com/page"},
+		{"https://example.com/#/settings", "https://example.com/#/settings"},
+		{"https://example.com/#!/inbox", "https://example.com/#!/inbox"},
+		{"https://example.com/#!inbox?utm_source=feed", "https://example.com/#!inbox?utm_source=feed"},
+		{"https://Example.com:443/?utm_source=feed#top", "https://example.com/"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeURL(tt.url); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		anyURL   bool
+		errorMsg string
+	}{
+		{"https://example.com/page", false, ""},
+		{"HTTP://example.com", false, ""},
+		{"  ", false, "must not be empty"},
+		{"not a url", false, "doesn't have scheme"},
+		{"example.com/page", false, "doesn't have scheme"},
+		{"javascript:alert(1)", false, "is a script"},
+		{"javascript:alert(1)", true, "is a script"},
+		{"ftp://example.com/file", false, "must use http or https"},
+		{"ftp://example.com/file", true, ""},
+		{"https:///path", false, "doesn't have host"},
+		{"http://exa mple.com/%zz", false, "is not valid"},
+	}
+
+	defer func(allow bool) { AllowAnyURLScheme = allow }(AllowAnyURLScheme)
+	for _, tt := range tests {
+		AllowAnyURLScheme = tt.anyURL
+		err := ValidateURL(tt.url)
+		switch {
+		case tt.errorMsg == "" && err != nil:
+			t.Errorf("%q: %v", tt.url, err)
+		case tt.errorMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errorMsg)):
+			t.Errorf("%q: got error %v, want one that contains %q", tt.url, err, tt.errorMsg)
+		}
+	}
+}
+
+func TestInsertBookmarkRejectsInvalidURL(t *testing.T) {
+	db := openTestDatabase(t)
+
+	book := model.Bookmark{URL: "not a url", Title: "Invalid"}
+	if err := db.InsertBookmark(context.Background(), &book); err == nil {
+		t.Error("bookmark with invalid URL is saved")
+	}
+	if _, _, err := db.UpsertBookmark(context.Background(), book); err == nil {
+		t.Error("bookmark with invalid URL is upserted")
+	}
+}
+
+func TestUpdateBookmarksRejectsInvalidURL(t *testing.T) {
+	db := openTestDatabase(t)
+	ctx := context.Background()
+
+	book := model.Bookmark{URL: "https://example.com/valid", Title: "Valid"}
+	if err := db.InsertBookmark(ctx, &book); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"", "  ", "javascript:alert(1)", "/relative/path"} {
+		invalid := book
+		invalid.URL = url
+		invalid.Title = "Changed"
+		if _, err := db.UpdateBookmarks(ctx, invalid); err == nil {
+			t.Errorf("%q: bookmark is updated with invalid URL", url)
+		}
+	}
+
+	saved, _, err := db.GetBookmark(ctx, book.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.URL != book.URL || saved.Title != book.Title {
+		t.Errorf("got %s titled %q, want the bookmark unchanged", saved.URL, saved.Title)
+	}
+}
@@ -1,10 +1,17 @@
 package database
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"src.techknowlogick.com/shiori/model"
 
@@ -17,42 +24,118 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// SQLiteDatabase is implementation of Database interface for connecting to SQLite3 database.
+// pgSearchVector is the text search document of a bookmark on PostgreSQL.
+// It must stay identical to the expression of bookmark_search_idx,
+// otherwise the planner won't use the index.
+const pgSearchVector = "to_tsvector('english', coalesce(title, '') || ' ' || coalesce(content, ''))"
+
+// pgPrefixQuery converts the keyword into tsquery where every word is matched as prefix,
+// e.g. "golan tut" becomes "golan:* & tut:*". Characters other than letters and digits
+// have special meaning in tsquery syntax, so they only separate words.
+func pgPrefixQuery(keyword string) string {
+	words := strings.FieldsFunc(keyword, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// XormDatabase is implementation of Database interface for any DBMS supported by xorm.
 type XormDatabase struct {
 	*xorm.Engine
 	dbType string
 }
 
-// OpenSQLiteDatabase creates and open connection to new SQLite3 database.
-func OpenXormDatabase(dsn, dbType string) (*XormDatabase, error) {
+var _ Database = (*XormDatabase)(nil)
+
+// BcryptCost is the cost used to hash the password of accounts.
+var BcryptCost = bcrypt.DefaultCost
+
+// MaxThumbnailSize is the max size in bytes of a thumbnail image. Bigger images aren't
+// downloaded, so bookmark keeps using its remote image.
+var MaxThumbnailSize int64 = 2 * 1024 * 1024
+
+// PoolConfig is the configuration for the connection pool of database.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultPoolConfig returns the pool configuration used when none is specified.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    100,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: 30 * time.Minute,
+	}
+}
+
+// OpenXormDatabase creates and open connection to database of the specified type.
+func OpenXormDatabase(dsn, dbType string, pool PoolConfig) (Database, error) {
 	// Open database and start transaction
 	db, err := xorm.NewEngine(dbType, dsn)
 	if err != nil {
-		return &XormDatabase{}, err
+		return nil, fmt.Errorf("failed to open %s database: %w", dbType, err)
 	}
-	err = db.Sync2(new(model.Tag), new(model.Bookmark), new(model.BookmarkTag), new(model.Account))
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	// Make sure the database is reachable before touching the schema
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %w", dbType, err)
+	}
+
+	err = db.Sync2(new(model.Tag), new(model.Bookmark), new(model.BookmarkTag), new(model.Account), new(model.Archive), new(model.Thumbnail), new(model.APIToken))
 	if err != nil {
-		return &XormDatabase{}, err
+		db.Close()
+		return nil, fmt.Errorf("failed to sync database schema: %w", err)
+	}
+	if err = migrate(db, dbType); err != nil {
+		db.Close()
+		return nil, err
 	}
 	return &XormDatabase{db, dbType}, nil
 }
 
 // InsertBookmark inserts new bookmark to database. Returns new ID and error if any happened.
-func (db *XormDatabase) InsertBookmark(bookmark *model.Bookmark) error {
+func (db *XormDatabase) InsertBookmark(ctx context.Context, bookmark *model.Bookmark) error {
 	// Check URL and title
-	if bookmark.URL == "" {
-		return fmt.Errorf("URL must not be empty")
+	if err := ValidateURL(bookmark.URL); err != nil {
+		return err
 	}
 
+	// Store the canonical form, so the same page isn't saved twice
+	bookmark.URL = NormalizeURL(bookmark.URL)
+
 	if bookmark.Title == "" {
-		return fmt.Errorf("Title must not be empty")
+		return fmt.Errorf("%w: Title must not be empty", ErrInvalidBookmark)
+	}
+
+	fillReadTime(bookmark)
+	truncateFields(bookmark)
+	fillDomain(bookmark)
+
+	// Keep modified time that set by caller, e.g. when importing
+	if bookmark.Modified.IsZero() {
+		bookmark.Modified = time.Now()
 	}
 
-	//	if bookmark.Modified == "" {
-	bookmark.Modified = time.Now()
-	//	}
+	// If the transaction is retried, start again from the submitted bookmark
+	submitted := *bookmark
+	return withRetry(ctx, func() error {
+		*bookmark = submitted
+		return db.insertBookmark(ctx, bookmark)
+	})
+}
 
-	session := db.NewSession()
+// insertBookmark inserts the validated bookmark in a transaction.
+func (db *XormDatabase) insertBookmark(ctx context.Context, bookmark *model.Bookmark) error {
+	session := db.NewSession().Context(ctx)
 	defer session.Close()
 
 	// add Begin() before any action
@@ -61,211 +144,2073 @@ func (db *XormDatabase) InsertBookmark(bookmark *model.Bookmark) error {
 		return err
 	}
 
+	if err := insertBookmarkRow(session, bookmark); err != nil {
+		return err
+	}
+	if err := saveBookmarkTags(session, bookmark); err != nil {
+		return err
+	}
+	return session.Commit()
+}
+
+// insertBookmarkRow inserts the validated bookmark without its tags, and fills its ID.
+// Returns ErrBookmarkExists if its URL already saved.
+func insertBookmarkRow(session *xorm.Session, bookmark *model.Bookmark) error {
+	// make sure the URL is not saved yet. Each account may save its own copy,
+	// while bookmark without owner is shared with everyone so it conflicts with any copy.
+	var existCond builder.Cond = builder.Eq{"url": bookmark.URL}
+	if bookmark.AccountID > 0 {
+		existCond = existCond.And(ownerCond(bookmark.AccountID))
+	}
+	exist, err := session.Where(existCond).Exist(&model.Bookmark{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrBookmarkExists
+	}
+
 	// create bookmark & get ID
-	session.Insert(bookmark)
-	for i := 0; i < len(bookmark.Tags); i++ {
+	_, err = session.Insert(bookmark)
+	return err
+}
+
+// UpsertBookmark inserts new bookmark to database or, if its URL already saved, updates the saved one.
+// Tags of the saved bookmark are kept and merged with the new ones. Returns ID of the bookmark and
+// whether it's newly created.
+func (db *XormDatabase) UpsertBookmark(ctx context.Context, bookmark model.Bookmark) (int, bool, error) {
+	// Check URL and title
+	if err := ValidateURL(bookmark.URL); err != nil {
+		return 0, false, err
+	}
+
+	// Store the canonical form, so the same page isn't saved twice
+	bookmark.URL = NormalizeURL(bookmark.URL)
+
+	if bookmark.Title == "" {
+		return 0, false, fmt.Errorf("%w: Title must not be empty", ErrInvalidBookmark)
+	}
+
+	fillReadTime(&bookmark)
+	truncateFields(&bookmark)
+	fillDomain(&bookmark)
+
+	if bookmark.Modified.IsZero() {
+		bookmark.Modified = time.Now()
+	}
+
+	var id int
+	var created bool
+	err := withRetry(ctx, func() (err error) {
+		id, created, err = db.upsertBookmark(ctx, bookmark)
+		return err
+	})
+	return id, created, err
+}
+
+// upsertBookmarkCols are the columns written when a saved bookmark is updated by UpsertBookmark,
+// even if they are empty in the new one, so cleared values replace the saved ones.
+var upsertBookmarkCols = []string{"excerpt", "author", "image_url", "content", "html", "has_content",
+	"min_read_time", "max_read_time", "is_read", "favorite", "note"}
+
+// upsertBookmark inserts or updates the validated bookmark in a transaction.
+func (db *XormDatabase) upsertBookmark(ctx context.Context, bookmark model.Bookmark) (int, bool, error) {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return 0, false, err
+	}
+
+	// Only the bookmarks accessible by the owner are updated, the same ones that
+	// InsertBookmark treats as already saved. Its own copy is preferred over the shared one.
+	var existCond builder.Cond = builder.Eq{"url": bookmark.URL}
+	if bookmark.AccountID > 0 {
+		existCond = existCond.And(ownerCond(bookmark.AccountID))
+	}
+
+	var saved model.Bookmark
+	exist, err := session.Where(existCond).Desc("account_id").Get(&saved)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if exist {
+		// Owner of the saved bookmark doesn't change
+		bookmark.ID = saved.ID
+		_, err = session.Where("id = ?", saved.ID).MustCols(upsertBookmarkCols...).
+			Omit("account_id").Update(&bookmark)
+	} else {
+		bookmark.ID = 0
+		_, err = session.Insert(&bookmark)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := saveBookmarkTags(session, &bookmark); err != nil {
+		return 0, false, err
+	}
+
+	if err := session.Commit(); err != nil {
+		return 0, false, err
+	}
+	return bookmark.ID, !exist, nil
+}
+
+// NormalizeTagName returns the form of tag name that saved in database, so "Go" and " go"
+// are the same tag. Tags are matched by their normalized name.
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// saveBookmarkTags creates the missing tags of the bookmark and assigns them to it.
+// Tags marked as deleted are skipped, and a tag is never assigned twice to the same bookmark.
+// Tag names are normalized, and matched case insensitively with the saved tags.
+func saveBookmarkTags(session *xorm.Session, bookmark *model.Bookmark) error {
+	tags := make([]model.Tag, 0, len(bookmark.Tags))
+	for _, bookmarkTag := range bookmark.Tags {
+		if bookmarkTag.Deleted {
+			continue
+		}
+		name := NormalizeTagName(bookmarkTag.Name)
+		if name == "" {
+			continue
+		}
+
 		var tag model.Tag
-		tag.Name = bookmark.Tags[i].Name
-		has, err := session.Exist(&tag)
+		has, err := session.Where("LOWER(name) = ?", name).Get(&tag)
 		if err != nil {
 			return err
 		}
 		if !has {
 			// create tag
-			session.Insert(&tag)
-		} else {
-			session.Where("name = ?", tag.Name).Get(&tag)
+			tag = model.Tag{Name: name}
+			if _, err = session.Insert(&tag); err != nil {
+				return err
+			}
+		}
+		// add bookmark_tag relation, unless it already exists
+		relation := model.BookmarkTag{BookmarkID: bookmark.ID, TagID: tag.ID}
+		has, err = session.Exist(&relation)
+		if err != nil {
+			return err
+		}
+		if !has {
+			if _, err = session.Insert(&relation); err != nil {
+				return err
+			}
+			tags = append(tags, tag)
 		}
-		bookmark.Tags[i] = tag
-		// add bookmark_tag relation
-		session.Insert(&model.BookmarkTag{BookmarkID: bookmark.ID, TagID: tag.ID})
 	}
-	session.Commit()
+	bookmark.Tags = tags
 	return nil
 }
 
-// GetBookmarks fetch list of bookmarks based on submitted ids.
-func (db *XormDatabase) GetBookmarks(withContent bool, ids ...int) ([]model.Bookmark, error) {
-	bookmarks := make([]model.Bookmark, 0)
-	var err error
-	if len(ids) > 0 {
-		err = db.In("id", ids).Find(&bookmarks)
-	} else {
-		err = db.Find(&bookmarks)
+// saveBookmarksTags is like saveBookmarkTags, but for many bookmarks at once. All tags are
+// resolved in one query, and all relations are inserted in one statement, instead of a few
+// statements for each tag of each bookmark. The bookmarks must not have any tag assigned yet.
+// Both queries are split in chunks, so they never bind more parameters than dbType allows.
+func saveBookmarksTags(session *xorm.Session, dbType string, bookmarks []model.Bookmark) error {
+	// collect the names of every tag
+	names := []string{}
+	seen := make(map[string]struct{})
+	for _, bookmark := range bookmarks {
+		for _, tag := range bookmark.Tags {
+			name := NormalizeTagName(tag.Name)
+			if tag.Deleted || name == "" {
+				continue
+			}
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				names = append(names, name)
+			}
+		}
 	}
-	for i := 0; i < len(bookmarks); i++ {
-		bookmarks[i].Tags = make([]model.Tag, 0)
-		tags := make([]model.Tag, 0)
-		bookmark := bookmarks[i]
-		db.Join("left", "bookmark_tag", "bookmark_tag.tag_id = tag.id").Where(builder.Eq{"bookmark_tag.bookmark_id": bookmark.ID}).Find(&tags)
-		bookmarks[i].Tags = tags
+
+	// resolve the existing tags, then create the missing ones.
+	// The tags are inserted one by one, since not every database returns
+	// the ids of rows inserted in one statement.
+	tagsByName := make(map[string]model.Tag, len(names))
+	size := chunkSize(dbType, 1)
+	for start := 0; start < len(names); start += size {
+		end := int(math.Min(float64(start+size), float64(len(names))))
+		existingTags := []model.Tag{}
+		err := session.Where(builder.In("LOWER(name)", names[start:end])).Find(&existingTags)
+		if err != nil {
+			return err
+		}
+		for _, tag := range existingTags {
+			tagsByName[NormalizeTagName(tag.Name)] = tag
+		}
 	}
-	return bookmarks, err
-}
 
-// DeleteBookmarks removes all record with matching ids from database.
-func (db *XormDatabase) DeleteBookmarks(ids ...int) error {
-	if len(ids) == 0 {
-		return db.deleteBookmarks()
+	for _, name := range names {
+		if _, ok := tagsByName[name]; ok {
+			continue
+		}
+		tag := model.Tag{Name: name}
+		if _, err := session.Insert(&tag); err != nil {
+			return err
+		}
+		tagsByName[name] = tag
 	}
 
-	page := 0
-	for len(ids) > page*100 {
-		upperIndex := int(math.Min(float64(page*100+100), float64(len(ids))))
-		err := db.deleteBookmarks(ids[page*100 : upperIndex]...)
-		if err != nil {
-			fmt.Println(err)
+	// assign the tags, skipping the ones that submitted twice for the same bookmark
+	relations := []model.BookmarkTag{}
+	for i, bookmark := range bookmarks {
+		tags := make([]model.Tag, 0, len(bookmark.Tags))
+		assigned := make(map[int]struct{}, len(bookmark.Tags))
+		for _, bookmarkTag := range bookmark.Tags {
+			name := NormalizeTagName(bookmarkTag.Name)
+			if bookmarkTag.Deleted || name == "" {
+				continue
+			}
+
+			tag := tagsByName[name]
+			if _, ok := assigned[tag.ID]; ok {
+				continue
+			}
+			assigned[tag.ID] = struct{}{}
+
+			relations = append(relations, model.BookmarkTag{BookmarkID: bookmark.ID, TagID: tag.ID})
+			tags = append(tags, tag)
+		}
+		bookmarks[i].Tags = tags
+	}
+
+	size = chunkSize(dbType, bookmarkTagColumns)
+	for start := 0; start < len(relations); start += size {
+		end := int(math.Min(float64(start+size), float64(len(relations))))
+		chunk := relations[start:end]
+		if _, err := session.Insert(&chunk); err != nil {
+			return err
 		}
-		page = page + 1
 	}
+
 	return nil
 }
 
-// deleteBookmarks removes all record with matching ids from database
-func (db *XormDatabase) deleteBookmarks(ids ...int) error {
-	var bookmark model.Bookmark
-	var err error
-	if len(ids) > 0 {
-		_, err = db.In("id", ids).Delete(&bookmark)
-	} else {
-		_, err = db.Delete(&bookmark)
+// InsertBookmarks inserts many new bookmarks to database in one transaction, which is much faster
+// than calling InsertBookmark for each of them. Returns IDs of the new bookmarks, in the same order
+// as bookmarks. If any bookmark is invalid or its URL already saved, none of them is saved.
+func (db *XormDatabase) InsertBookmarks(ctx context.Context, bookmarks []model.Bookmark, progress ProgressFunc) ([]int, error) {
+	now := time.Now()
+	tagNames := make([]string, 0)
+	seenTags := make(map[string]struct{})
+	for i := range bookmarks {
+		book := &bookmarks[i]
+		if err := ValidateURL(book.URL); err != nil {
+			return nil, err
+		}
+
+		// Store the canonical form, so the same page isn't saved twice
+		book.URL = NormalizeURL(book.URL)
+
+		if book.Title == "" {
+			return nil, fmt.Errorf("Title of %s must not be empty", book.URL)
+		}
+
+		fillReadTime(book)
+		truncateFields(book)
+		fillDomain(book)
+
+		// Keep modified time that set by caller, e.g. when importing
+		if book.Modified.IsZero() {
+			book.Modified = now
+		}
+
+		for j := range book.Tags {
+			book.Tags[j].Name = NormalizeTagName(book.Tags[j].Name)
+		}
+
+		for _, tag := range book.Tags {
+			if _, seen := seenTags[tag.Name]; !tag.Deleted && tag.Name != "" && !seen {
+				seenTags[tag.Name] = struct{}{}
+				tagNames = append(tagNames, tag.Name)
+			}
+		}
 	}
-	return err
-}
 
-// SearchBookmarks search bookmarks by the keyword or tags.
-func (db *XormDatabase) SearchBookmarks(orderLatest bool, keyword string, tags ...string) ([]model.Bookmark, error) {
-	//var bookmarks []model.Bookmark
-	bookmarks := make([]model.Bookmark, 0)
-	searchCond := builder.NewCond()
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
 
-	if len(keyword) > 0 {
-		keyword = strings.TrimSpace(keyword)
-		lowerKeyword := strings.ToLower(keyword)
-		exprCond := builder.Or(
-			builder.Like{"title", lowerKeyword},
-			builder.Like{"content", lowerKeyword},
-		)
-		keywordCond := builder.Or(
-			builder.Like{"url", lowerKeyword},
-			exprCond,
-		)
-		searchCond = searchCond.And(keywordCond)
+	if err := session.Begin(); err != nil {
+		return nil, err
 	}
 
-	if len(tags) > 0 {
-		tagsCond := builder.In("id", builder.Select("bookmark_id").From("bookmark_tag").LeftJoin("tag", builder.Expr("tag.id = bookmark_tag.tag_id")).Where(builder.In("tag.name", tags)))
-		searchCond = searchCond.And(tagsCond)
+	reporter := newProgressReporter(progress, len(bookmarks))
+	defer reporter.stop()
+
+	// IDs of multi-row insert can't be fetched in every DBMS,
+	// so bookmarks are inserted one by one, but still in the same transaction
+	ids := make([]int, 0, len(bookmarks))
+	for i := range bookmarks {
+		if err := insertBookmarkRow(session, &bookmarks[i]); err != nil {
+			return nil, err
+		}
+		ids = append(ids, bookmarks[i].ID)
+		reporter.report(len(ids))
 	}
 
-	err := db.Where(searchCond).Desc("created").Find(&bookmarks)
+	tagIDs, err := saveTagsByName(session, db.dbType, tagNames)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(bookmarks); i++ {
-		bookmarks[i].Tags = make([]model.Tag, 0)
-		tags := make([]model.Tag, 0)
-		bookmark := bookmarks[i]
-		db.Join("left", "bookmark_tag", "bookmark_tag.tag_id = tag.id").Where(builder.Eq{"bookmark_tag.bookmark_id": bookmark.ID}).Find(&tags)
+	// Assign the tags using multi-row insert
+	relations := make([]model.BookmarkTag, 0)
+	for i := range bookmarks {
+		tags := make([]model.Tag, 0, len(bookmarks[i].Tags))
+		assigned := make(map[int]struct{})
+		for _, tag := range bookmarks[i].Tags {
+			if tag.Deleted || tag.Name == "" {
+				continue
+			}
+			tag.ID = tagIDs[tag.Name]
+			if _, exist := assigned[tag.ID]; exist {
+				continue
+			}
+			assigned[tag.ID] = struct{}{}
+			tags = append(tags, tag)
+			relations = append(relations, model.BookmarkTag{BookmarkID: bookmarks[i].ID, TagID: tag.ID})
+		}
 		bookmarks[i].Tags = tags
 	}
 
-	return bookmarks, err
+	size := chunkSize(db.dbType, bookmarkTagColumns)
+	for start := 0; start < len(relations); start += size {
+		end := int(math.Min(float64(start+size), float64(len(relations))))
+		chunk := relations[start:end]
+		if _, err := session.Insert(&chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := session.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
-// UpdateBookmarks updates the saved bookmark in database.
-func (db *XormDatabase) UpdateBookmarks(bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
-	result = []model.Bookmark{}
-	session := db.NewSession()
-	defer session.Close()
+// Number of columns written for each row of multi-row inserts.
+const (
+	bookmarkTagColumns = 2 // bookmark_id, tag_id
+	tagColumns         = 6 // name, description, deleted, n_bookmarks, created, updated
+)
 
-	// add Begin() before any action
-	if err := session.Begin(); err != nil {
-		// if returned then will rollback automatically
-		return []model.Bookmark{}, err
+// maxQueryParams returns the max number of parameters that can be bound
+// in one statement of the database.
+func maxQueryParams(dbType string) int {
+	switch dbType {
+	case "sqlite3":
+		return 999
+	case "mssql":
+		return 2100
+	default:
+		return 65535
 	}
-	for _, bookmark := range bookmarks {
-		// create bookmark & get ID
-		session.Update(&bookmark)
-		// clear existing tag assignments
-		session.Where("bookmark_id = ?", bookmark.ID).Delete(&model.BookmarkTag{})
-		// insert & assign tag assignments
-		for i := 0; i < len(bookmark.Tags); i++ {
-			var tag model.Tag
-			tag.Name = bookmark.Tags[i].Name
-			has, err := session.Exist(&tag)
-			if err != nil {
-				return []model.Bookmark{}, err
+}
+
+// chunkSize returns how many rows with that number of columns can be written in one statement.
+// Each row binds one parameter for each column, so the size depends on the database. It's never
+// more than 500 rows though, to keep the statements small. For IN lists, columns is 1.
+func chunkSize(dbType string, columns int) int {
+	return int(math.Min(500, float64(maxQueryParams(dbType)/columns)))
+}
+
+// saveTagsByName creates the tags with matching names that don't exist yet.
+// The names must be normalized, and they are matched case insensitively with the saved tags.
+// Returns the ID of every tag with matching names, mapped by their names.
+func saveTagsByName(session *xorm.Session, dbType string, names []string) (map[string]int, error) {
+	tagIDs := make(map[string]int, len(names))
+	findTagIDs := func(names []string) error {
+		size := chunkSize(dbType, 1)
+		for start := 0; start < len(names); start += size {
+			end := int(math.Min(float64(start+size), float64(len(names))))
+			tags := make([]model.Tag, 0)
+			if err := session.Where(builder.In("LOWER(name)", names[start:end])).Find(&tags); err != nil {
+				return err
 			}
-			if !has {
-				// create tag
-				session.Insert(&tag)
-			} else {
-				session.Where("name = ?", tag.Name).Get(&tag)
+			for _, tag := range tags {
+				tagIDs[strings.ToLower(tag.Name)] = tag.ID
 			}
-			bookmark.Tags[i] = tag
-			// add bookmark_tag relation
-			session.Insert(&model.BookmarkTag{BookmarkID: bookmark.ID, TagID: tag.ID})
 		}
-		result = append(result, bookmark)
+		return nil
 	}
-	session.Commit()
-	return result, nil
+
+	if err := findTagIDs(names); err != nil {
+		return nil, err
+	}
+
+	missingNames := make([]string, 0)
+	missingTags := make([]model.Tag, 0)
+	for _, name := range names {
+		if _, exist := tagIDs[name]; !exist {
+			missingNames = append(missingNames, name)
+			missingTags = append(missingTags, model.Tag{Name: name})
+		}
+	}
+	if len(missingTags) == 0 {
+		return tagIDs, nil
+	}
+
+	size := chunkSize(dbType, tagColumns)
+	for start := 0; start < len(missingTags); start += size {
+		end := int(math.Min(float64(start+size), float64(len(missingTags))))
+		chunk := missingTags[start:end]
+		if _, err := session.Insert(&chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	// Like bookmarks, IDs of the new tags can't be taken from the insert
+	if err := findTagIDs(missingNames); err != nil {
+		return nil, err
+	}
+	return tagIDs, nil
 }
 
-// CreateAccount saves new account to database. Returns new ID and error if any happened.
-func (db *XormDatabase) CreateAccount(username, password string) error {
-	// Hash password with bcrypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	if err != nil {
-		return err
+// GetBookmarks fetch list of bookmarks based on submitted ids. Unless opts has its own
+// ordering, the bookmarks are returned in the same order as ids.
+func (db *XormDatabase) GetBookmarks(ctx context.Context, withContent bool, opts ListOptions, ids ...int) ([]model.Bookmark, error) {
+	bookmarks := make([]model.Bookmark, 0)
+	session := withListOptions(db.Context(ctx), opts)
+	if opts.OrderBy == OrderDefault {
+		session = session.Asc("id")
 	}
-	_, err = db.Insert(&model.Account{Username: username, Password: string(hashedPassword)})
-	return err
+	if len(ids) > 0 {
+		session = session.In("id", ids)
+	}
+	if opts.AccountID > 0 {
+		session = session.Where(ownerCond(opts.AccountID))
+	}
+	if opts.IncludeDeleted {
+		session = session.Unscoped()
+	}
+	if !withContent {
+		session = session.Omit("content", "html")
+	}
+	if err := session.Find(&bookmarks); err != nil {
+		return bookmarks, err
+	}
+	if len(ids) > 0 && opts.OrderBy == OrderDefault && !opts.FavoriteFirst {
+		sortBookmarksByIDs(bookmarks, ids)
+	}
+	err := db.loadBookmarkDetails(ctx, bookmarks)
+	return bookmarks, err
 }
 
-// GetAccount fetch account with matching username
-func (db *XormDatabase) GetAccount(username string) (model.Account, error) {
-	var account model.Account
-	_, err := db.Where("username = ?", username).Get(&account)
-	return account, err
+// sortBookmarksByIDs sorts bookmarks in the same order as their ID in ids.
+// If an ID is listed more than once, its first position is used.
+func sortBookmarksByIDs(bookmarks []model.Bookmark, ids []int) {
+	positions := make(map[int]int, len(ids))
+	for i, id := range ids {
+		if _, exist := positions[id]; !exist {
+			positions[id] = i
+		}
+	}
+
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		return positions[bookmarks[i].ID] < positions[bookmarks[j].ID]
+	})
 }
 
-// GetAccounts fetch list of accounts with matching keyword
-func (db *XormDatabase) GetAccounts(keyword string) ([]model.Account, error) {
-	var accounts []model.Account
-	var err error
-	if keyword == "" {
-		err = db.Where(builder.Like{"username", keyword}).Find(&accounts)
-	} else {
-		err = db.Find(&accounts)
+// GetBookmark fetch bookmark with matching id. Returns false if it doesn't exist.
+func (db *XormDatabase) GetBookmark(ctx context.Context, id int, withContent bool) (model.Bookmark, bool, error) {
+	bookmark := model.Bookmark{}
+	session := db.Context(ctx).Where("id = ?", id)
+	if !withContent {
+		session = session.Omit("content", "html")
 	}
-	return accounts, err
+	has, err := session.Get(&bookmark)
+	if err != nil || !has {
+		return model.Bookmark{}, false, err
+	}
+
+	bookmarks := []model.Bookmark{bookmark}
+	if err = db.loadBookmarkDetails(ctx, bookmarks); err != nil {
+		return model.Bookmark{}, false, err
+	}
+	return bookmarks[0], true, nil
 }
 
-// DeleteAccounts removes all record with matching usernames
-func (db *XormDatabase) DeleteAccounts(usernames ...string) error {
-	var account model.Account
-	var err error
-	if len(usernames) > 0 {
-		_, err = db.Where("username in (?)", usernames).Delete(&account)
-	} else {
-		_, err = db.Delete(&account)
+// bookmarkTagRow is a row of bookmark_tag joined with its tag.
+type bookmarkTagRow struct {
+	model.BookmarkTag `xorm:"extends"`
+	model.Tag         `xorm:"extends"`
+}
+
+func (bookmarkTagRow) TableName() string {
+	return "bookmark_tag"
+}
+
+// findBookmarkTags fetch tags of bookmarks with matching ids, sorted by name.
+func (db *XormDatabase) findBookmarkTags(ctx context.Context, ids ...int) ([]bookmarkTagRow, error) {
+	rows := make([]bookmarkTagRow, 0)
+	err := db.Context(ctx).Join("INNER", "tag", "tag.id = bookmark_tag.tag_id").
+		In("bookmark_tag.bookmark_id", ids).
+		Asc("tag.name").
+		Find(&rows)
+	return rows, err
+}
+
+// GetTagsForBookmark fetch tags of bookmark with matching id, sorted by name.
+func (db *XormDatabase) GetTagsForBookmark(ctx context.Context, bookmarkID int) ([]model.Tag, error) {
+	rows, err := db.findBookmarkTags(ctx, bookmarkID)
+	if err != nil {
+		return nil, err
 	}
-	return err
+
+	tags := make([]model.Tag, 0, len(rows))
+	for _, row := range rows {
+		tags = append(tags, row.Tag)
+	}
+	return tags, nil
 }
 
-// GetTags fetch list of tags and their frequency
-func (db *XormDatabase) GetTags() ([]model.Tag, error) {
-	tags := make([]model.Tag, 0)
-	err := db.Table("tag").Select("bookmark_tag.tag_id as id, tag.name, COUNT(bookmark_tag.tag_id) as n_bookmarks").
-		Join("left", "bookmark_tag", "bookmark_tag.tag_id = tag.id").
-		GroupBy("bookmark_tag.tag_id, tag.name").Find(&tags)
+// loadBookmarkDetails fills the tags, archive and thumbnail flags of bookmarks using a few queries
+// per 500 bookmarks, instead of querying them for each bookmark.
+func (db *XormDatabase) loadBookmarkDetails(ctx context.Context, bookmarks []model.Bookmark) error {
+	bookmarkIndex := make(map[int]int, len(bookmarks))
+	ids := make([]int, 0, len(bookmarks))
+	for i := range bookmarks {
+		bookmarks[i].Tags = make([]model.Tag, 0)
+		bookmarkIndex[bookmarks[i].ID] = i
+		ids = append(ids, bookmarks[i].ID)
+	}
 
-	return tags, err
+	for start := 0; start < len(ids); start += 500 {
+		end := int(math.Min(float64(start+500), float64(len(ids))))
+		rows, err := db.findBookmarkTags(ctx, ids[start:end]...)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			i := bookmarkIndex[row.BookmarkID]
+			bookmarks[i].Tags = append(bookmarks[i].Tags, row.Tag)
+		}
+
+		archivedIDs := make([]int, 0)
+		err = db.Context(ctx).Table("archive").Cols("bookmark_id").
+			In("bookmark_id", ids[start:end]).
+			Find(&archivedIDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range archivedIDs {
+			bookmarks[bookmarkIndex[id]].HasArchive = true
+		}
+
+		thumbnailIDs := make([]int, 0)
+		err = db.Context(ctx).Table("thumbnail").Cols("bookmark_id").
+			In("bookmark_id", ids[start:end]).
+			Find(&thumbnailIDs)
+		if err != nil {
+			return err
+		}
+		for _, id := range thumbnailIDs {
+			bookmarks[bookmarkIndex[id]].HasThumbnail = true
+		}
+	}
+	return nil
 }
 
-// GetBookmarkID fetchs bookmark ID based by its url
-func (db *XormDatabase) GetBookmarkID(url string) int {
-	var bookmark model.Bookmark
-	db.Where("url = ?", url).Get(&bookmark)
+// GetBookmarksModifiedSince fetch bookmarks that saved, updated or moved to trash after t,
+// oldest first. Bookmarks in trash have non zero DeletedAt, so client can remove them.
+// It uses the updated column instead of modified, since modified keeps the original
+// date of imported bookmarks and isn't changed when bookmark updated.
+// If accountID is not zero, only the bookmarks accessible by account with matching id are fetched.
+func (db *XormDatabase) GetBookmarksModifiedSince(ctx context.Context, accountID int, t time.Time, withContent bool) ([]model.Bookmark, error) {
+	cond := builder.Or(builder.Gt{"updated": t}, builder.Gt{"deleted_at": t})
+	if accountID > 0 {
+		cond = builder.And(cond, ownerCond(accountID))
+	}
+
+	bookmarks := make([]model.Bookmark, 0)
+	session := db.Context(ctx).Unscoped().Where(cond)
+	if !withContent {
+		session = session.Omit("content", "html")
+	}
+	if err := session.Asc("updated", "id").Find(&bookmarks); err != nil {
+		return bookmarks, err
+	}
+	err := db.loadBookmarkDetails(ctx, bookmarks)
+	return bookmarks, err
+}
+
+// GetUntaggedBookmarks fetch list of bookmarks that don't have any tags. If accountID is
+// not zero, only the bookmarks accessible by account with matching id are fetched.
+func (db *XormDatabase) GetUntaggedBookmarks(ctx context.Context, accountID int, withContent bool) ([]model.Bookmark, error) {
+	cond := builder.NotIn("id", builder.Select("bookmark_id").From("bookmark_tag"))
+	if accountID > 0 {
+		cond = builder.And(cond, ownerCond(accountID))
+	}
+
+	bookmarks := make([]model.Bookmark, 0)
+	session := db.Context(ctx).Where(cond)
+	if !withContent {
+		session = session.Omit("content", "html")
+	}
+	if err := session.Asc("id").Find(&bookmarks); err != nil {
+		return bookmarks, err
+	}
+	err := db.loadBookmarkDetails(ctx, bookmarks)
+	return bookmarks, err
+}
+
+// DeleteBookmarks moves bookmarks with matching ids to trash. Bookmarks in trash are
+// excluded from query results, until they are restored by RestoreBookmarks.
+// If no ids given, nothing is deleted. Use DeleteAllBookmarks to delete every bookmark.
+func (db *XormDatabase) DeleteBookmarks(ctx context.Context, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	page := 0
+	for len(ids) > page*100 {
+		upperIndex := int(math.Min(float64(page*100+100), float64(len(ids))))
+		chunk := ids[page*100 : upperIndex]
+		err := withRetry(ctx, func() error {
+			return db.deleteBookmarks(ctx, chunk...)
+		})
+		if err != nil {
+			return err
+		}
+		page = page + 1
+	}
+	return nil
+}
+
+// DeleteAllBookmarks moves every bookmark to trash.
+func (db *XormDatabase) DeleteAllBookmarks(ctx context.Context) error {
+	return withRetry(ctx, func() error {
+		return db.deleteBookmarks(ctx)
+	})
+}
+
+// deleteBookmarks moves bookmarks with matching ids to trash
+func (db *XormDatabase) deleteBookmarks(ctx context.Context, ids ...int) error {
+	session := db.Context(ctx)
+	if len(ids) > 0 {
+		session = session.In("id", ids)
+	} else {
+		// xorm refuses to delete without any condition
+		session = session.Where("1 = 1")
+	}
+
+	// Bookmark has deleted tag, so xorm only sets its deleted_at
+	_, err := session.Delete(&model.Bookmark{})
+	return err
+}
+
+// RestoreBookmarks moves bookmarks with matching ids back from trash.
+// If no ids given, all bookmarks in trash are restored.
+func (db *XormDatabase) RestoreBookmarks(ctx context.Context, ids ...int) error {
+	session := db.Context(ctx).Unscoped()
+	if len(ids) > 0 {
+		session = session.In("id", ids)
+	} else {
+		session = session.Where("deleted_at IS NOT NULL")
+	}
+
+	_, err := session.SetExpr("deleted_at", "NULL").Update(&model.Bookmark{})
+	return err
+}
+
+// PurgeBookmarks permanently removes bookmarks with matching ids from database,
+// including their tags, archive and thumbnail. If no ids given, all bookmarks in trash are removed.
+func (db *XormDatabase) PurgeBookmarks(ctx context.Context, ids ...int) error {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		err := session.Unscoped().Table("bookmark").Cols("id").Where("deleted_at IS NOT NULL").Find(&ids)
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(ids); start += 100 {
+		end := int(math.Min(float64(start+100), float64(len(ids))))
+		chunk := ids[start:end]
+
+		if _, err := session.In("bookmark_id", chunk).Delete(&model.BookmarkTag{}); err != nil {
+			return err
+		}
+
+		if _, err := session.In("bookmark_id", chunk).Delete(&model.Archive{}); err != nil {
+			return err
+		}
+
+		if _, err := session.In("bookmark_id", chunk).Delete(&model.Thumbnail{}); err != nil {
+			return err
+		}
+
+		if _, err := session.Unscoped().In("id", chunk).Delete(&model.Bookmark{}); err != nil {
+			return err
+		}
+	}
+
+	return session.Commit()
+}
+
+// searchCond creates the condition that matches the bookmarks searched with opts.
+// ListOptions other than AccountID, and IncludeDeleted, are not part of the condition.
+func (db *XormDatabase) searchCond(opts SearchOptions) builder.Cond {
+	searchCond := builder.NewCond()
+
+	keyword := strings.TrimSpace(opts.Keyword)
+	if len(keyword) > 0 {
+		lowerKeyword := strings.ToLower(keyword)
+		exprCond := builder.Or(
+			builder.Like{"title", lowerKeyword},
+			builder.Like{"content", lowerKeyword},
+		)
+		switch db.dbType {
+		case "postgres":
+			if !opts.Prefix {
+				exprCond = builder.Expr(pgSearchVector+" @@ plainto_tsquery('english', ?)", keyword)
+			} else if tsQuery := pgPrefixQuery(keyword); tsQuery != "" {
+				exprCond = builder.Expr(pgSearchVector+" @@ to_tsquery('english', ?)", tsQuery)
+			}
+		case "sqlite3":
+			if matchQuery := sqliteMatchQuery(keyword, opts.Prefix); matchQuery != "" {
+				exprCond = builder.In("id", builder.Select("docid").From("bookmark_fts").
+					Where(builder.Expr("bookmark_fts MATCH ?", matchQuery)))
+			}
+		}
+		keywordCond := builder.Or(
+			builder.Like{"url", lowerKeyword},
+			builder.Like{"note", lowerKeyword},
+			exprCond,
+		)
+		searchCond = searchCond.And(keywordCond)
+	}
+
+	if len(opts.Tags) > 0 {
+		tagNames := make([]string, len(opts.Tags))
+		for i, tag := range opts.Tags {
+			tagNames[i] = NormalizeTagName(tag)
+		}
+		tagsCond := builder.In("id", builder.Select("bookmark_id").From("bookmark_tag").LeftJoin("tag", builder.Expr("tag.id = bookmark_tag.tag_id")).Where(builder.In("LOWER(tag.name)", tagNames)))
+		searchCond = searchCond.And(tagsCond)
+	}
+
+	if domain := normalizeDomain(opts.Domain); domain != "" {
+		searchCond = searchCond.And(builder.Eq{"domain": domain})
+	}
+
+	if author := strings.TrimSpace(opts.Author); author != "" {
+		searchCond = searchCond.And(builder.Eq{"author": author})
+	}
+
+	if !opts.StartDate.IsZero() {
+		searchCond = searchCond.And(builder.Gte{"modified": opts.StartDate})
+	}
+
+	if !opts.EndDate.IsZero() {
+		searchCond = searchCond.And(builder.Lte{"modified": opts.EndDate})
+	}
+
+	if opts.ReadOnly {
+		searchCond = searchCond.And(builder.Eq{"is_read": true})
+	}
+
+	if opts.UnreadOnly {
+		searchCond = searchCond.And(builder.Eq{"is_read": false})
+	}
+
+	if opts.FavoriteOnly {
+		searchCond = searchCond.And(builder.Eq{"favorite": true})
+	}
+
+	if opts.ArchivedOnly {
+		searchCond = searchCond.And(builder.In("id", builder.Select("bookmark_id").From("archive")))
+	}
+
+	if opts.UnarchivedOnly {
+		searchCond = searchCond.And(builder.NotIn("id", builder.Select("bookmark_id").From("archive")))
+	}
+
+	if opts.AccountID > 0 {
+		searchCond = searchCond.And(ownerCond(opts.AccountID))
+	}
+
+	return searchCond
+}
+
+// SearchBookmarks search bookmarks by the keyword or tags.
+func (db *XormDatabase) SearchBookmarks(ctx context.Context, opts SearchOptions) ([]model.Bookmark, int, error) {
+	//var bookmarks []model.Bookmark
+	bookmarks := make([]model.Bookmark, 0)
+	searchCond := db.searchCond(opts)
+
+	countSession := db.Context(ctx).Where(searchCond)
+	if opts.IncludeDeleted {
+		countSession = countSession.Unscoped()
+	}
+	total, err := countSession.Count(&model.Bookmark{})
+	if err != nil {
+		return bookmarks, 0, err
+	}
+
+	keyword := strings.TrimSpace(opts.Keyword)
+	if opts.OrderBy == OrderRelevance && keyword != "" {
+		bookmarks, err = db.searchByRelevance(ctx, searchCond, keyword, opts)
+	} else {
+		session := withListOptions(db.Context(ctx).Where(searchCond), opts.ListOptions)
+		if opts.IncludeDeleted {
+			session = session.Unscoped()
+		}
+		switch opts.OrderBy {
+		case OrderDefault:
+			if opts.OrderLatest {
+				session = session.Desc("created")
+			} else {
+				session = session.Asc("id")
+			}
+		case OrderRelevance:
+			session = session.Desc("id")
+		}
+		err = session.Find(&bookmarks)
+	}
+	if err != nil {
+		return bookmarks, 0, err
+	}
+
+	if opts.Snippet && keyword != "" {
+		if err = db.fillSnippets(ctx, bookmarks, keyword, opts.Prefix); err != nil {
+			return bookmarks, 0, err
+		}
+	}
+
+	err = db.loadBookmarkDetails(ctx, bookmarks)
+	return bookmarks, int(total), err
+}
+
+// ownerCond matches the bookmarks owned by the account and the ones without owner.
+func ownerCond(accountID int) builder.Cond {
+	return builder.In("account_id", 0, accountID)
+}
+
+// accountBookmarks selects id of the bookmarks that not in trash and accessible by
+// account with matching id, to be used as subquery.
+func accountBookmarks(accountID int) *builder.Builder {
+	return builder.Select("id").From("bookmark").
+		Where(builder.And(ownerCond(accountID), builder.IsNull{"deleted_at"}))
+}
+
+// searchByRelevance fetch the bookmarks matched by searchCond, the most relevant to keyword first.
+// On PostgreSQL they're ranked by ts_rank of their title and content, while on other databases
+// the bookmarks whose title contains keyword come first. Xorm can't bind arguments in ORDER BY,
+// so the ids are selected by raw query before the bookmarks themselves are fetched.
+func (db *XormDatabase) searchByRelevance(ctx context.Context, searchCond builder.Cond, keyword string, opts SearchOptions) ([]model.Bookmark, error) {
+	bookmarks := make([]model.Bookmark, 0)
+	if !opts.IncludeDeleted {
+		searchCond = builder.And(searchCond, builder.IsNull{"deleted_at"})
+	}
+
+	condSQL, args, err := builder.ToSQL(searchCond)
+	if err != nil {
+		return bookmarks, err
+	}
+
+	rank := "CASE WHEN LOWER(title) LIKE ? THEN 1 ELSE 0 END"
+	rankArg := "%" + strings.ToLower(keyword) + "%"
+	if db.dbType == "postgres" {
+		rank = "ts_rank(" + pgSearchVector + ", plainto_tsquery('english', ?))"
+		rankArg = keyword
+		if tsQuery := pgPrefixQuery(keyword); opts.Prefix && tsQuery != "" {
+			rank = "ts_rank(" + pgSearchVector + ", to_tsquery('english', ?))"
+			rankArg = tsQuery
+		}
+	}
+
+	order := rank + " DESC, id DESC"
+	if opts.FavoriteFirst {
+		order = "favorite DESC, " + order
+	}
+
+	query := "SELECT id FROM bookmark"
+	if condSQL != "" {
+		query += " WHERE " + condSQL
+	}
+	query += " ORDER BY " + order
+	args = append(args, rankArg)
+	if opts.Limit > 0 {
+		query += limitClause(db.dbType, opts.Limit, opts.Offset)
+	}
+
+	ids := make([]int, 0)
+	if err = db.Context(ctx).SQL(query, args...).Find(&ids); err != nil {
+		return bookmarks, err
+	}
+	if len(ids) == 0 {
+		return bookmarks, nil
+	}
+
+	err = db.Context(ctx).Unscoped().In("id", ids).Find(&bookmarks)
+	if err != nil {
+		return bookmarks, err
+	}
+
+	sortBookmarksByIDs(bookmarks, ids)
+	return bookmarks, nil
+}
+
+// limitClause returns the clause that limits rows of a raw query that has ORDER BY,
+// like session.Limit does for the queries built by xorm. SQL Server doesn't support
+// LIMIT, so it uses OFFSET FETCH instead.
+func limitClause(dbType string, limit, offset int) string {
+	if dbType == "mssql" {
+		return fmt.Sprintf(" OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", offset, limit)
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
+
+// withListOptions applies the ordering, limit and offset of opts to the session.
+// It must be called before the query adds its own ordering.
+func withListOptions(session *xorm.Session, opts ListOptions) *xorm.Session {
+	if opts.FavoriteFirst {
+		session = session.Desc("favorite")
+	}
+	if columns, desc := opts.OrderBy.columns(); len(columns) > 0 {
+		if desc {
+			session = session.Desc(columns...)
+		} else {
+			session = session.Asc(columns...)
+		}
+
+		// Keep the order stable for bookmarks with the same value
+		if opts.OrderBy != OrderIDAsc && opts.OrderBy != OrderIDDesc {
+			session = session.Asc("id")
+		}
+	}
+	if opts.Limit > 0 {
+		session = session.Limit(opts.Limit, opts.Offset)
+	}
+	return session
+}
+
+// UpdateBookmarks updates the saved bookmark in database.
+func (db *XormDatabase) UpdateBookmarks(ctx context.Context, bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
+	err = withRetry(ctx, func() error {
+		result, err = db.updateBookmarks(ctx, bookmarks...)
+		return err
+	})
+	return result, err
+}
+
+// updateBookmarks updates the bookmarks in a transaction.
+func (db *XormDatabase) updateBookmarks(ctx context.Context, bookmarks ...model.Bookmark) (result []model.Bookmark, err error) {
+	result = []model.Bookmark{}
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	// add Begin() before any action
+	if err := session.Begin(); err != nil {
+		// if returned then will rollback automatically
+		return []model.Bookmark{}, err
+	}
+	ids := make([]int, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		// Updated URL must be valid just like the new one
+		if err := ValidateURL(bookmark.URL); err != nil {
+			return []model.Bookmark{}, err
+		}
+
+		// Keep the canonical form, so the duplicate checks still find the bookmark
+		bookmark.URL = NormalizeURL(bookmark.URL)
+
+		fillReadTime(&bookmark)
+		truncateFields(&bookmark)
+		fillDomain(&bookmark)
+
+		// update bookmark, including the fields that may be cleared.
+		// Each bookmark is updated on its own, since only its non-empty fields are changed.
+		_, err := session.Where("id = ?", bookmark.ID).MustCols("is_read", "favorite", "note").Update(&bookmark)
+		if err != nil {
+			return []model.Bookmark{}, err
+		}
+		ids = append(ids, bookmark.ID)
+		result = append(result, bookmark)
+	}
+	if len(ids) > 0 {
+		// clear existing tag assignments
+		size := chunkSize(db.dbType, 1)
+		for start := 0; start < len(ids); start += size {
+			end := int(math.Min(float64(start+size), float64(len(ids))))
+			_, err = session.In("bookmark_id", ids[start:end]).Delete(&model.BookmarkTag{})
+			if err != nil {
+				return []model.Bookmark{}, err
+			}
+		}
+		// insert & assign tag assignments of every bookmark at once
+		if err := saveBookmarksTags(session, db.dbType, result); err != nil {
+			return []model.Bookmark{}, err
+		}
+	}
+	if err := session.Commit(); err != nil {
+		return []model.Bookmark{}, err
+	}
+	return result, nil
+}
+
+// RecomputeReadTimes estimates reading time of every bookmark from its content.
+// Returns the number of updated bookmarks.
+func (db *XormDatabase) RecomputeReadTimes(ctx context.Context) (int, error) {
+	nUpdated := 0
+	lastID := 0
+	for {
+		// Content may be big, so only load 100 bookmarks at once
+		bookmarks := make([]model.Bookmark, 0)
+		err := db.Context(ctx).Cols("id", "content", "min_read_time", "max_read_time").
+			Where("id > ?", lastID).
+			Asc("id").
+			Limit(100).
+			Find(&bookmarks)
+		if err != nil {
+			return nUpdated, err
+		}
+		if len(bookmarks) == 0 {
+			return nUpdated, nil
+		}
+
+		for _, bookmark := range bookmarks {
+			lastID = bookmark.ID
+			minReadTime, maxReadTime := EstimateReadTime(bookmark.Content)
+			if minReadTime == bookmark.MinReadTime && maxReadTime == bookmark.MaxReadTime {
+				continue
+			}
+
+			_, err = db.Context(ctx).Where("id = ?", bookmark.ID).
+				Cols("min_read_time", "max_read_time").
+				Update(&model.Bookmark{MinReadTime: minReadTime, MaxReadTime: maxReadTime})
+			if err != nil {
+				return nUpdated, err
+			}
+			nUpdated++
+		}
+	}
+}
+
+// UpdateBookmarkTags adds and removes tags of a bookmark without updating the bookmark itself.
+func (db *XormDatabase) UpdateBookmarkTags(ctx context.Context, bookmarkID int, addTags []string, removeTags []string) error {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	has, err := session.Where("id = ?", bookmarkID).Exist(&model.Bookmark{})
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("Bookmark with id %d doesn't exist", bookmarkID)
+	}
+
+	// add new tags
+	bookmark := model.Bookmark{ID: bookmarkID}
+	for _, name := range addTags {
+		bookmark.Tags = append(bookmark.Tags, model.Tag{Name: name})
+	}
+	if err := saveBookmarkTags(session, &bookmark); err != nil {
+		return err
+	}
+
+	// remove old tags
+	if len(removeTags) > 0 {
+		removedNames := make([]string, len(removeTags))
+		for i, name := range removeTags {
+			removedNames[i] = NormalizeTagName(name)
+		}
+
+		var removedIDs []int
+		err = session.Table("tag").Cols("id").Where(builder.In("LOWER(name)", removedNames)).Find(&removedIDs)
+		if err != nil {
+			return err
+		}
+
+		if len(removedIDs) > 0 {
+			_, err = session.Where("bookmark_id = ?", bookmarkID).In("tag_id", removedIDs).Delete(&model.BookmarkTag{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := touchBookmarks(session, bookmarkID); err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// AddTagToBookmarks assigns a tag to all bookmarks with matching ids.
+// The tag is created if needed, and bookmarks that already have it or are in trash are left untouched.
+func (db *XormDatabase) AddTagToBookmarks(ctx context.Context, tagName string, bookmarkIDs []int) error {
+	if len(bookmarkIDs) == 0 {
+		return nil
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	// resolve the tag once
+	tagName = NormalizeTagName(tagName)
+	if tagName == "" {
+		return fmt.Errorf("Tag name must not be empty")
+	}
+
+	var tag model.Tag
+	has, err := session.Where("LOWER(name) = ?", tagName).Get(&tag)
+	if err != nil {
+		return err
+	}
+	if !has {
+		tag = model.Tag{Name: tagName}
+		if _, err = session.Insert(&tag); err != nil {
+			return err
+		}
+	}
+
+	// Tag the bookmarks in chunks, so neither the IN lists nor
+	// the multi-row insert bind more parameters than the database allows
+	size := chunkSize(db.dbType, bookmarkTagColumns)
+	for start := 0; start < len(bookmarkIDs); start += size {
+		end := int(math.Min(float64(start+size), float64(len(bookmarkIDs))))
+		if err := addTagToBookmarks(session, tag, bookmarkIDs[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return session.Commit()
+}
+
+// addTagToBookmarks assigns tag to bookmarks with matching ids that not in trash
+// and don't have it yet.
+func addTagToBookmarks(session *xorm.Session, tag model.Tag, bookmarkIDs []int) error {
+	// find which bookmarks exist and which of them already have the tag
+	var existingIDs, taggedIDs []int
+	err := session.Table("bookmark").Cols("id").In("id", bookmarkIDs).
+		Where(builder.IsNull{"deleted_at"}).Find(&existingIDs)
+	if err != nil {
+		return err
+	}
+
+	err = session.Table("bookmark_tag").Cols("bookmark_id").
+		Where("tag_id = ?", tag.ID).In("bookmark_id", bookmarkIDs).Find(&taggedIDs)
+	if err != nil {
+		return err
+	}
+
+	alreadyTagged := make(map[int]struct{}, len(taggedIDs))
+	for _, id := range taggedIDs {
+		alreadyTagged[id] = struct{}{}
+	}
+
+	relations := []model.BookmarkTag{}
+	for _, id := range existingIDs {
+		if _, tagged := alreadyTagged[id]; !tagged {
+			relations = append(relations, model.BookmarkTag{BookmarkID: id, TagID: tag.ID})
+		}
+	}
+	if len(relations) == 0 {
+		return nil
+	}
+
+	// insert all new relations in one statement
+	if _, err = session.Insert(&relations); err != nil {
+		return err
+	}
+
+	newIDs := make([]int, len(relations))
+	for i, relation := range relations {
+		newIDs[i] = relation.BookmarkID
+	}
+	return touchBookmarks(session, newIDs...)
+}
+
+// touchBookmarks sets the update time of bookmarks with matching ids to now,
+// since changing their tags doesn't change the bookmark rows themselves.
+func touchBookmarks(session *xorm.Session, ids ...int) error {
+	_, err := session.Table("bookmark").In("id", ids).
+		Update(map[string]interface{}{"updated": time.Now()})
+	return err
+}
+
+// touchTaggedBookmarks is like touchBookmarks, for every bookmark that has any of
+// tags with matching ids, since renaming or removing a tag changes them as well.
+func touchTaggedBookmarks(session *xorm.Session, tagIDs ...int) error {
+	taggedBookmarks := builder.Select("bookmark_id").From("bookmark_tag").Where(builder.In("tag_id", tagIDs))
+	_, err := session.Table("bookmark").Where(builder.In("id", taggedBookmarks)).
+		Update(map[string]interface{}{"updated": time.Now()})
+	return err
+}
+
+// SaveArchive saves archived copy of a bookmark, replacing the old one if any.
+func (db *XormDatabase) SaveArchive(ctx context.Context, bookmarkID int, data []byte, mime string) error {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	exist, err := session.Where("id = ?", bookmarkID).Exist(&model.Bookmark{})
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("Bookmark %d doesn't exist", bookmarkID)
+	}
+
+	_, err = session.Where("bookmark_id = ?", bookmarkID).Delete(&model.Archive{})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Insert(&model.Archive{BookmarkID: bookmarkID, Mime: mime, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// GetArchive fetch archived copy of a bookmark and its MIME type.
+func (db *XormDatabase) GetArchive(ctx context.Context, bookmarkID int) ([]byte, string, error) {
+	var archive model.Archive
+	has, err := db.Context(ctx).Where("bookmark_id = ?", bookmarkID).Get(&archive)
+	if err != nil {
+		return nil, "", err
+	}
+	if !has {
+		return nil, "", fmt.Errorf("Bookmark %d doesn't have archive", bookmarkID)
+	}
+	return archive.Data, archive.Mime, nil
+}
+
+// SaveThumbnail saves the image of a bookmark, replacing the old one if any.
+func (db *XormDatabase) SaveThumbnail(ctx context.Context, bookmarkID int, data []byte, mime string) error {
+	if int64(len(data)) > MaxThumbnailSize {
+		return fmt.Errorf("Thumbnail is larger than %d bytes", MaxThumbnailSize)
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	exist, err := session.Where("id = ?", bookmarkID).Exist(&model.Bookmark{})
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("Bookmark %d doesn't exist", bookmarkID)
+	}
+
+	_, err = session.Where("bookmark_id = ?", bookmarkID).Delete(&model.Thumbnail{})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Insert(&model.Thumbnail{BookmarkID: bookmarkID, Mime: mime, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// GetThumbnail fetch image of a bookmark and its MIME type.
+func (db *XormDatabase) GetThumbnail(ctx context.Context, bookmarkID int) ([]byte, string, error) {
+	var thumbnail model.Thumbnail
+	has, err := db.Context(ctx).Where("bookmark_id = ?", bookmarkID).Get(&thumbnail)
+	if err != nil {
+		return nil, "", err
+	}
+	if !has {
+		return nil, "", fmt.Errorf("Bookmark %d doesn't have thumbnail", bookmarkID)
+	}
+	return thumbnail.Data, thumbnail.Mime, nil
+}
+
+// CreateAccount saves new account to database. Returns new ID and error if any happened.
+func (db *XormDatabase) CreateAccount(ctx context.Context, username, password string, isAdmin bool) error {
+	// Hash password with bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.Context(ctx).Insert(&model.Account{Username: username, Password: string(hashedPassword), IsAdmin: isAdmin})
+	return err
+}
+
+// SetAccountAdmin grants or revokes the admin role of account with matching username.
+func (db *XormDatabase) SetAccountAdmin(ctx context.Context, username string, admin bool) error {
+	exist, err := db.Context(ctx).Where("username = ?", username).Exist(&model.Account{})
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("Account %s doesn't exist", username)
+	}
+
+	_, err = db.Context(ctx).Where("username = ?", username).Cols("is_admin").
+		Update(&model.Account{IsAdmin: admin})
+	return err
+}
+
+// UpdateAccountPassword changes the password of account with matching username.
+func (db *XormDatabase) UpdateAccountPassword(ctx context.Context, username, newPassword string) error {
+	// Hash password with bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), BcryptCost)
+	if err != nil {
+		return err
+	}
+
+	affected, err := db.Context(ctx).Where("username = ?", username).Cols("password").
+		Update(&model.Account{Password: string(hashedPassword)})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("Account %s doesn't exist", username)
+	}
+	return nil
+}
+
+// GetAccount fetch account with matching username
+func (db *XormDatabase) GetAccount(ctx context.Context, username string) (model.Account, error) {
+	var account model.Account
+	_, err := db.Context(ctx).Where("username = ?", username).Get(&account)
+	return account, err
+}
+
+// GetAccountByID fetch account with matching ID. Returns ErrAccountNotFound if it doesn't exist.
+func (db *XormDatabase) GetAccountByID(ctx context.Context, id int) (model.Account, error) {
+	var account model.Account
+	has, err := db.Context(ctx).Where("id = ?", id).Get(&account)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if !has {
+		return model.Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
+
+// VerifyAccount fetch account with matching username and checks its password.
+// Returns ErrInvalidCredentials if the account doesn't exist or the password is wrong.
+func (db *XormDatabase) VerifyAccount(ctx context.Context, username, password string) (model.Account, error) {
+	account := model.Account{}
+	has, err := db.Context(ctx).Where("username = ?", username).Get(&account)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if !has {
+		return model.Account{}, ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password))
+	if err != nil {
+		return model.Account{}, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// GetAccounts fetch list of accounts with matching keyword.
+// If exactMatch is true, the username must equal to the keyword, ignoring case.
+func (db *XormDatabase) GetAccounts(ctx context.Context, keyword string, exactMatch bool) ([]model.Account, error) {
+	accounts := make([]model.Account, 0)
+	session := db.Context(ctx)
+	lowerKeyword := strings.ToLower(keyword)
+	if exactMatch {
+		session = session.Where("LOWER(username) = ?", lowerKeyword)
+	} else if keyword != "" {
+		session = session.Where("LOWER(username) LIKE ?", "%"+lowerKeyword+"%")
+	}
+	err := session.Asc("username").Find(&accounts)
+	return accounts, err
+}
+
+// DeleteAccounts removes all record with matching usernames, including their API tokens.
+// If no usernames given, nothing is deleted. Use DeleteAllAccounts to delete every account.
+func (db *XormDatabase) DeleteAccounts(ctx context.Context, usernames ...string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	accountIDs := builder.Select("id").From("account").Where(builder.In("username", usernames))
+	_, err := session.Where(builder.In("account_id", accountIDs)).Delete(&model.APIToken{})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.In("username", usernames).Delete(&model.Account{})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// DeleteAllAccounts removes every account, including their API tokens.
+func (db *XormDatabase) DeleteAllAccounts(ctx context.Context) error {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	// xorm refuses to delete without any condition
+	_, err := session.Where("1 = 1").Delete(&model.APIToken{})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Where("1 = 1").Delete(&model.Account{})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// hashAPIToken returns the hash of API token that stored in database.
+// The token is random enough, so unlike password it doesn't need a slow hash.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates new API token for account with matching id.
+// Returns the token, which can't be retrieved again later.
+func (db *XormDatabase) CreateAPIToken(ctx context.Context, accountID int, label string) (string, error) {
+	exist, err := db.Context(ctx).Where("id = ?", accountID).Exist(&model.Account{})
+	if err != nil {
+		return "", err
+	}
+	if !exist {
+		return "", fmt.Errorf("Account %d doesn't exist", accountID)
+	}
+
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, err = db.Context(ctx).Insert(&model.APIToken{
+		TokenHash: hashAPIToken(token),
+		AccountID: accountID,
+		Label:     label,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResolveAPIToken fetch account that owns the token.
+// Returns ErrInvalidAPIToken if the token doesn't exist or has been revoked.
+func (db *XormDatabase) ResolveAPIToken(ctx context.Context, token string) (model.Account, error) {
+	var apiToken model.APIToken
+	has, err := db.Context(ctx).Where("token_hash = ?", hashAPIToken(token)).Get(&apiToken)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if !has {
+		return model.Account{}, ErrInvalidAPIToken
+	}
+
+	var account model.Account
+	has, err = db.Context(ctx).Where("id = ?", apiToken.AccountID).Get(&account)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if !has {
+		return model.Account{}, ErrInvalidAPIToken
+	}
+	return account, nil
+}
+
+// GetAPITokens fetch list of API tokens owned by account with matching id.
+func (db *XormDatabase) GetAPITokens(ctx context.Context, accountID int) ([]model.APIToken, error) {
+	tokens := make([]model.APIToken, 0)
+	err := db.Context(ctx).Where("account_id = ?", accountID).Asc("id").Find(&tokens)
+	return tokens, err
+}
+
+// DeleteAPITokens revokes API tokens with matching ids.
+func (db *XormDatabase) DeleteAPITokens(ctx context.Context, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := db.Context(ctx).In("id", ids).Delete(&model.APIToken{})
+	return err
+}
+
+// GetTags fetch list of tags and their frequency. If accountID is not zero, only
+// the tags used by bookmarks accessible by account with matching id are returned,
+// and only those bookmarks are counted. Bookmarks in trash are never counted.
+func (db *XormDatabase) GetTags(ctx context.Context, accountID int) ([]model.Tag, error) {
+	tags := make([]model.Tag, 0)
+	if accountID > 0 {
+		err := db.Context(ctx).Table("tag").Select("tag.id, tag.name, tag.description, COUNT(bookmark_tag.bookmark_id) as n_bookmarks").
+			Join("inner", "bookmark_tag", "bookmark_tag.tag_id = tag.id").
+			Where(builder.In("bookmark_tag.bookmark_id", accountBookmarks(accountID))).
+			GroupBy("tag.id, tag.name, tag.description").Find(&tags)
+
+		return tags, err
+	}
+
+	// Unused tags are listed too, so bookmarks in trash are excluded in the join instead of
+	// filtering the joined rows
+	err := db.Context(ctx).Table("tag").Select("tag.id, tag.name, tag.description, COUNT(bookmark_tag.tag_id) as n_bookmarks").
+		Join("left", "bookmark_tag", "bookmark_tag.tag_id = tag.id AND "+
+			"bookmark_tag.bookmark_id IN (SELECT id FROM bookmark WHERE deleted_at IS NULL)").
+		GroupBy("tag.id, tag.name, tag.description").Find(&tags)
+
+	return tags, err
+}
+
+// GetAuthors fetch the distinct authors of bookmarks that not in trash, sorted by name.
+// Bookmarks without author are skipped. If accountID is not zero, only the bookmarks
+// accessible by account with matching id are checked.
+func (db *XormDatabase) GetAuthors(ctx context.Context, accountID int) ([]string, error) {
+	cond := builder.And(builder.Neq{"author": ""}, builder.IsNull{"deleted_at"})
+	if accountID > 0 {
+		cond = cond.And(ownerCond(accountID))
+	}
+
+	authors := make([]string, 0)
+	err := db.Context(ctx).Table("bookmark").Distinct("author").
+		Where(cond).Asc("author").Find(&authors)
+
+	return authors, err
+}
+
+// GetDomains fetch the domains of bookmarks that not in trash and the number of
+// bookmarks in each of them, the most bookmarked domain first. If accountID is not zero,
+// only the bookmarks accessible by account with matching id are counted.
+func (db *XormDatabase) GetDomains(ctx context.Context, accountID int) ([]model.DomainCount, error) {
+	cond := builder.And(builder.Neq{"domain": ""}, builder.IsNull{"deleted_at"})
+	if accountID > 0 {
+		cond = cond.And(ownerCond(accountID))
+	}
+
+	domains := make([]model.DomainCount, 0)
+	err := db.Context(ctx).Table("bookmark").Select("domain, COUNT(*) AS n_bookmarks").
+		Where(cond).GroupBy("domain").OrderBy("n_bookmarks DESC, domain ASC").Find(&domains)
+
+	return domains, err
+}
+
+// GetTagsForSearch fetch list of tags and their frequency within the bookmarks that
+// matched by keyword and tags, the same way as SearchBookmarks. Tags that not used by
+// any of those bookmarks are omitted. If accountID is not zero, only the bookmarks
+// accessible by account with matching id are counted.
+func (db *XormDatabase) GetTagsForSearch(ctx context.Context, accountID int, keyword string, tags ...string) ([]model.Tag, error) {
+	searchCond := db.searchCond(SearchOptions{
+		ListOptions: ListOptions{AccountID: accountID},
+		Keyword:     keyword,
+		Tags:        tags,
+	})
+	matchedBookmarks := builder.Select("id").From("bookmark").
+		Where(builder.And(searchCond, builder.IsNull{"deleted_at"}))
+
+	result := make([]model.Tag, 0)
+	err := db.Context(ctx).Table("tag").Select("tag.id, tag.name, tag.description, COUNT(bookmark_tag.bookmark_id) as n_bookmarks").
+		Join("inner", "bookmark_tag", "bookmark_tag.tag_id = tag.id").
+		Where(builder.In("bookmark_tag.bookmark_id", matchedBookmarks)).
+		GroupBy("tag.id, tag.name, tag.description").
+		Asc("tag.name").Find(&result)
+
+	return result, err
+}
+
+// likeReplacer escapes the wildcards of LIKE pattern with backslash.
+var likeReplacer = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix returns LIKE pattern that matches the strings starting with prefix.
+// Wildcards in prefix are matched literally, so the pattern must be used with likeEscape.
+func likePrefix(prefix string) string {
+	return likeReplacer.Replace(prefix) + "%"
+}
+
+// likeEscape returns the ESCAPE clause for patterns made by likePrefix. MySQL reads
+// backslash in string literal as escape character, so it's doubled there.
+func (db *XormDatabase) likeEscape() string {
+	if db.dbType == "mysql" {
+		return ` ESCAPE '\\'`
+	}
+	return ` ESCAPE '\'`
+}
+
+// SearchTags fetch tags whose name starts with prefix, ignoring case.
+// The most used tags come first. Zero limit means no limit. If accountID is not zero,
+// only the tags used by bookmarks accessible by account with matching id are returned.
+func (db *XormDatabase) SearchTags(ctx context.Context, accountID int, prefix string, limit int) ([]model.Tag, error) {
+	tags := make([]model.Tag, 0)
+	session := db.Context(ctx).Table("tag").Select("tag.id, tag.name, tag.description, COUNT(bookmark_tag.tag_id) as n_bookmarks").
+		Where("LOWER(tag.name) LIKE ?"+db.likeEscape(), likePrefix(strings.ToLower(prefix)))
+	if accountID > 0 {
+		session = session.Join("inner", "bookmark_tag", "bookmark_tag.tag_id = tag.id").
+			And(builder.In("bookmark_tag.bookmark_id", accountBookmarks(accountID)))
+	} else {
+		session = session.Join("left", "bookmark_tag", "bookmark_tag.tag_id = tag.id")
+	}
+	session = session.GroupBy("tag.id, tag.name, tag.description").
+		Desc("n_bookmarks").Asc("tag.name")
+	if limit > 0 {
+		session = session.Limit(limit)
+	}
+	err := session.Find(&tags)
+	return tags, err
+}
+
+// GetRelatedTags fetch tags that assigned to the same bookmarks as tag with matching id.
+// NBookmark of the returned tags is the count of bookmarks they share with that tag,
+// and tags sharing the most bookmarks come first. Zero limit means no limit. If accountID
+// is not zero, only the bookmarks accessible by account with matching id are checked.
+func (db *XormDatabase) GetRelatedTags(ctx context.Context, accountID int, tagID int, limit int) ([]model.Tag, error) {
+	taggedBookmarks := builder.Select("bookmark_id").From("bookmark_tag").Where(builder.Eq{"tag_id": tagID})
+
+	cond := builder.In("bookmark_tag.bookmark_id", taggedBookmarks).And(builder.Neq{"bookmark_tag.tag_id": tagID})
+	if accountID > 0 {
+		cond = cond.And(builder.In("bookmark_tag.bookmark_id", accountBookmarks(accountID)))
+	}
+
+	tags := make([]model.Tag, 0)
+	session := db.Context(ctx).Table("bookmark_tag").Select("tag.id, tag.name, tag.description, COUNT(bookmark_tag.bookmark_id) as n_bookmarks").
+		Join("INNER", "tag", "tag.id = bookmark_tag.tag_id").
+		Where(cond).
+		GroupBy("tag.id, tag.name, tag.description").
+		Desc("n_bookmarks").Asc("tag.name")
+	if limit > 0 {
+		session = session.Limit(limit)
+	}
+	err := session.Find(&tags)
+	return tags, err
+}
+
+// MarkRead sets the read status of bookmarks with matching ids.
+func (db *XormDatabase) MarkRead(ctx context.Context, ids []int, read bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := db.Context(ctx).In("id", ids).Cols("is_read").Update(&model.Bookmark{Read: read})
+	return err
+}
+
+// MarkFavorite sets the favorite status of bookmarks with matching ids.
+func (db *XormDatabase) MarkFavorite(ctx context.Context, ids []int, favorite bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := db.Context(ctx).In("id", ids).Cols("favorite").Update(&model.Bookmark{Favorite: favorite})
+	return err
+}
+
+// UpdateTag changes the name and description of tag with matching id.
+// Unlike RenameTag, it fails if another tag already uses the new name.
+func (db *XormDatabase) UpdateTag(ctx context.Context, id int, name, description string) error {
+	name = NormalizeTagName(name)
+	if name == "" {
+		return fmt.Errorf("Tag name must not be empty")
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	exist, err := session.Where("id = ?", id).Exist(&model.Tag{})
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("Tag %d doesn't exist", id)
+	}
+
+	exist, err = session.Where("LOWER(name) = ? AND id <> ?", name, id).Exist(&model.Tag{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return fmt.Errorf("Tag %s already exists", name)
+	}
+
+	if err := touchTaggedBookmarks(session, id); err != nil {
+		return err
+	}
+
+	_, err = session.Where("id = ?", id).Cols("name", "description").
+		Update(&model.Tag{Name: name, Description: description})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// RenameTag renames a tag. If a tag with the new name already exists,
+// both tags are merged into it.
+func (db *XormDatabase) RenameTag(ctx context.Context, oldName, newName string) error {
+	oldName = NormalizeTagName(oldName)
+	newName = NormalizeTagName(newName)
+	if newName == "" {
+		return fmt.Errorf("New tag name must not be empty")
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	var oldTag model.Tag
+	has, err := session.Where("LOWER(name) = ?", oldName).Get(&oldTag)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("Tag %s doesn't exist", oldName)
+	}
+
+	var newTag model.Tag
+	has, err = session.Where("LOWER(name) = ?", newName).Get(&newTag)
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		if err = touchTaggedBookmarks(session, oldTag.ID); err != nil {
+			return err
+		}
+		_, err = session.Where("id = ?", oldTag.ID).Cols("name").Update(&model.Tag{Name: newName})
+	} else if newTag.ID != oldTag.ID {
+		err = mergeTag(session, oldTag.ID, newTag.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// MergeTags moves all bookmarks of the source tags to the target tag,
+// then removes the source tags.
+func (db *XormDatabase) MergeTags(ctx context.Context, sourceIDs []int, targetID int) error {
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	has, err := session.Where("id = ?", targetID).Exist(&model.Tag{})
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("Tag with id %d doesn't exist", targetID)
+	}
+
+	for _, sourceID := range sourceIDs {
+		if sourceID == targetID {
+			continue
+		}
+		if err := mergeTag(session, sourceID, targetID); err != nil {
+			return err
+		}
+	}
+
+	return session.Commit()
+}
+
+// mergeTag moves all bookmarks of the source tag to the target tag, then removes the source tag.
+// Bookmarks that already have the target tag simply lose the source tag.
+func mergeTag(session *xorm.Session, sourceID, targetID int) error {
+	if err := touchTaggedBookmarks(session, sourceID); err != nil {
+		return err
+	}
+
+	// find bookmarks that already have the target tag
+	var taggedIDs []int
+	err := session.Table("bookmark_tag").Cols("bookmark_id").Where("tag_id = ?", targetID).Find(&taggedIDs)
+	if err != nil {
+		return err
+	}
+
+	// drop their source tag, so no duplicate pair is made
+	if len(taggedIDs) > 0 {
+		_, err = session.Where("tag_id = ?", sourceID).In("bookmark_id", taggedIDs).Delete(&model.BookmarkTag{})
+		if err != nil {
+			return err
+		}
+	}
+
+	// move the rest to the target tag
+	_, err = session.Where("tag_id = ?", sourceID).Cols("tag_id").Update(&model.BookmarkTag{TagID: targetID})
+	if err != nil {
+		return err
+	}
+
+	_, err = session.Where("id = ?", sourceID).Delete(&model.Tag{})
+	return err
+}
+
+// DeleteTags removes tags with matching ids, including tags that still used by bookmarks.
+// Their bookmarks are kept, only the tag assignments are removed.
+func (db *XormDatabase) DeleteTags(ctx context.Context, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	session := db.NewSession().Context(ctx)
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	if err := touchTaggedBookmarks(session, ids...); err != nil {
+		return err
+	}
+
+	if _, err := session.In("tag_id", ids).Delete(&model.BookmarkTag{}); err != nil {
+		return err
+	}
+
+	if _, err := session.In("id", ids).Delete(&model.Tag{}); err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// unusedTagsCond matches the tags that not used by any bookmarks, including the ones in trash.
+func unusedTagsCond() builder.Cond {
+	return builder.NotIn("id", builder.Select("tag_id").From("bookmark_tag"))
+}
+
+// GetUnusedTags fetch the tags that not used by any bookmarks, including the ones in trash.
+// They are the tags that removed by DeleteUnusedTags.
+func (db *XormDatabase) GetUnusedTags(ctx context.Context) ([]model.Tag, error) {
+	tags := make([]model.Tag, 0)
+	err := db.Context(ctx).Where(unusedTagsCond()).Asc("name").Find(&tags)
+	return tags, err
+}
+
+// DeleteUnusedTags removes all tags that not used by any bookmarks.
+// Returns the count of removed tags.
+func (db *XormDatabase) DeleteUnusedTags(ctx context.Context) (int, error) {
+	nDeleted, err := db.Context(ctx).Where(unusedTagsCond()).Delete(&model.Tag{})
+	return int(nDeleted), err
+}
+
+// DeleteEmptyTags removes tags whose name is empty or only whitespace, which may be saved
+// before empty tag names are skipped. Returns the count of removed tags.
+func (db *XormDatabase) DeleteEmptyTags(ctx context.Context) (int, error) {
+	var ids []int
+	err := db.Context(ctx).Table("tag").Cols("id").
+		Where("name IS NULL OR LTRIM(RTRIM(name)) = ''").
+		Find(&ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = db.DeleteTags(ctx, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// FindBookmarksByURLPrefix fetch bookmarks whose URL starts with prefix.
+func (db *XormDatabase) FindBookmarksByURLPrefix(ctx context.Context, prefix string) ([]model.Bookmark, error) {
+	candidates := make([]model.Bookmark, 0)
+	err := db.Context(ctx).Omit("content", "html").
+		Where("url LIKE ?", prefix+"%").
+		Asc("id").
+		Find(&candidates)
+	if err != nil {
+		return candidates, err
+	}
+
+	// prefix may contain LIKE wildcards, so check the matches again here
+	// instead of escaping them in a way that every DBMS understands
+	bookmarks := make([]model.Bookmark, 0, len(candidates))
+	for _, bookmark := range candidates {
+		if strings.HasPrefix(bookmark.URL, prefix) {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+
+	err = db.loadBookmarkDetails(ctx, bookmarks)
+	return bookmarks, err
+}
+
+// FindDuplicateBookmarks fetch bookmarks whose URL is the same as url
+// after both are normalized by NormalizeURL.
+func (db *XormDatabase) FindDuplicateBookmarks(ctx context.Context, url string) ([]model.Bookmark, error) {
+	normalizedURL := NormalizeURL(url)
+
+	// Duplicates must share the part before query and fragment
+	prefix := normalizedURL
+	if idx := strings.IndexAny(prefix, "?#"); idx >= 0 {
+		prefix = prefix[:idx]
+	}
+
+	candidates, err := db.FindBookmarksByURLPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarks := make([]model.Bookmark, 0)
+	for _, bookmark := range candidates {
+		if NormalizeURL(bookmark.URL) == normalizedURL {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+	return bookmarks, nil
+}
+
+// GetBookmarkID fetchs bookmark ID based by its url
+func (db *XormDatabase) GetBookmarkID(ctx context.Context, url string) int {
+	var bookmark model.Bookmark
+	db.Context(ctx).Where("url = ?", url).Get(&bookmark)
 	return bookmark.ID
 }
+
+// GetStats returns the summary of bookmarks that accessible by account with matching id.
+// If accountID is 0, every bookmark and tag is counted. Otherwise only the tags used by
+// the bookmarks of the account are counted.
+func (db *XormDatabase) GetStats(ctx context.Context, accountID int) (model.Stats, error) {
+	stats := model.Stats{}
+	bookmarks := func() *xorm.Session {
+		session := db.Context(ctx)
+		if accountID > 0 {
+			session = session.Where(ownerCond(accountID))
+		}
+		return session
+	}
+
+	nBookmarks, err := bookmarks().Count(&model.Bookmark{})
+	if err != nil {
+		return stats, err
+	}
+
+	nRead, err := bookmarks().Where("is_read = ?", true).Count(&model.Bookmark{})
+	if err != nil {
+		return stats, err
+	}
+
+	nArchived, err := bookmarks().
+		Where(builder.In("id", builder.Select("bookmark_id").From("archive"))).
+		Count(&model.Bookmark{})
+	if err != nil {
+		return stats, err
+	}
+
+	var nTags int64
+	if accountID > 0 {
+		_, err = db.Context(ctx).Table("bookmark_tag").Select("COUNT(DISTINCT tag_id)").
+			Where(builder.In("bookmark_id", accountBookmarks(accountID))).
+			Get(&nTags)
+	} else {
+		nTags, err = db.Context(ctx).Count(&model.Tag{})
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	var lastModified model.Bookmark
+	_, err = bookmarks().Cols("modified").Desc("modified").Get(&lastModified)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Bookmarks = int(nBookmarks)
+	stats.Tags = int(nTags)
+	stats.Read = int(nRead)
+	stats.Unread = int(nBookmarks - nRead)
+	stats.Archived = int(nArchived)
+	stats.LastModified = lastModified.Modified
+	return stats, nil
+}
+
+// GetMaxModified returns the last time any bookmark that accessible by account with
+// matching id is changed, and the number of those bookmarks. Trashed bookmarks are checked
+// as well, so moving a bookmark to trash also changes the returned time. Purged bookmarks
+// can't be checked, but they change the number of bookmarks.
+func (db *XormDatabase) GetMaxModified(ctx context.Context, accountID int) (time.Time, int, error) {
+	bookmarks := func() *xorm.Session {
+		session := db.Context(ctx).Unscoped()
+		if accountID > 0 {
+			session = session.Where(ownerCond(accountID))
+		}
+		return session
+	}
+
+	nBookmarks, err := bookmarks().Count(&model.Bookmark{})
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	maxModified := time.Time{}
+	for _, col := range []string{"modified", "updated", "deleted_at"} {
+		var bookmark model.Bookmark
+		_, err := bookmarks().Cols(col).Where(builder.NotNull{col}).Desc(col).Get(&bookmark)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+
+		for _, t := range []time.Time{bookmark.Modified, bookmark.Updated, bookmark.DeletedAt} {
+			if t.After(maxModified) {
+				maxModified = t
+			}
+		}
+	}
+
+	return maxModified, int(nBookmarks), nil
+}
+
+// Ping makes sure the database is reachable by running a trivial query.
+func (db *XormDatabase) Ping(ctx context.Context) error {
+	_, err := db.Context(ctx).Exec("SELECT 1")
+	return err
+}
+
+// Stats returns the statistics of database connection pool.
+func (db *XormDatabase) Stats() sql.DBStats {
+	return db.DB().Stats()
+}
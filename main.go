@@ -2,17 +2,23 @@ package main
 
 import (
 	"fmt"
+	"net"
+	nurl "net/url"
 	"os"
 	fp "path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"src.techknowlogick.com/shiori/cmd"
 	dt "src.techknowlogick.com/shiori/database"
+	"src.techknowlogick.com/shiori/readability"
 )
 
 var dataDir = "."
 
 func main() {
+	var err error
 	dbType := "sqlite3"
 	dsn := fp.Join(dataDir, "shiori.db")
 
@@ -37,16 +43,102 @@ func main() {
 		postgresqlDBUser := os.Getenv("SHIORI_POSTGRESQL_USER")
 		postgresqlDBPass := os.Getenv("SHIORI_POSTGRESQL_PASS")
 		postgresqlDBHost := os.Getenv("SHIORI_POSTGRESQL_HOST")
+		postgresqlDBPort := os.Getenv("SHIORI_POSTGRESQL_PORT")
+		postgresqlSSLMode := os.Getenv("SHIORI_POSTGRESQL_SSLMODE")
+		if postgresqlDBHost == "" {
+			postgresqlDBHost = "localhost"
+		}
+		if postgresqlDBPort == "" {
+			postgresqlDBPort = "5432"
+		}
+		if postgresqlSSLMode == "" {
+			postgresqlSSLMode = "disable"
+		}
 		dbType = "postgres"
-		dsn = fmt.Sprintf("user=%s password=%s host=%s dbname=%s sslmode=disable", postgresqlDBUser, postgresqlDBPass, postgresqlDBHost, postgresqlDBName)
+		postgresqlURL := nurl.URL{
+			Scheme:   "postgres",
+			User:     nurl.UserPassword(postgresqlDBUser, postgresqlDBPass),
+			Host:     net.JoinHostPort(postgresqlDBHost, postgresqlDBPort),
+			Path:     "/" + postgresqlDBName,
+			RawQuery: nurl.Values{"sslmode": {postgresqlSSLMode}}.Encode(),
+		}
+		dsn = postgresqlURL.String()
 	}
 
-	xormDB, err := dt.OpenXormDatabase(dsn, dbType)
-	checkError(err)
+	// set up connection pool
+	pool := dt.DefaultPoolConfig()
+	if dbType == "sqlite3" {
+		pool = dt.SQLitePoolConfig()
+	}
+	if maxOpenConns := os.Getenv("SHIORI_DB_MAX_OPEN_CONNS"); maxOpenConns != "" {
+		pool.MaxOpenConns, err = strconv.Atoi(maxOpenConns)
+		checkError(err)
+	}
+	if maxIdleConns := os.Getenv("SHIORI_DB_MAX_IDLE_CONNS"); maxIdleConns != "" {
+		pool.MaxIdleConns, err = strconv.Atoi(maxIdleConns)
+		checkError(err)
+	}
+	if connMaxLifetime := os.Getenv("SHIORI_DB_CONN_MAX_LIFETIME"); connMaxLifetime != "" {
+		pool.ConnMaxLifetime, err = time.ParseDuration(connMaxLifetime)
+		checkError(err)
+	}
+
+	if bcryptCost := os.Getenv("SHIORI_BCRYPT_COST"); bcryptCost != "" {
+		dt.BcryptCost, err = strconv.Atoi(bcryptCost)
+		checkError(err)
+	}
+
+	if allowAnyURLScheme := os.Getenv("SHIORI_ALLOW_ANY_URL_SCHEME"); allowAnyURLScheme != "" {
+		dt.AllowAnyURLScheme, err = strconv.ParseBool(allowAnyURLScheme)
+		checkError(err)
+	}
+
+	if maxThumbnailSize := os.Getenv("SHIORI_THUMBNAIL_MAX_BYTES"); maxThumbnailSize != "" {
+		dt.MaxThumbnailSize, err = strconv.ParseInt(maxThumbnailSize, 10, 64)
+		checkError(err)
+	}
+
+	// set up how pages are fetched
+	if fetchTimeout := os.Getenv("SHIORI_FETCH_TIMEOUT"); fetchTimeout != "" {
+		readability.Config.Timeout, err = time.ParseDuration(fetchTimeout)
+		checkError(err)
+	}
+	if userAgent := os.Getenv("SHIORI_FETCH_USER_AGENT"); userAgent != "" {
+		readability.Config.UserAgent = userAgent
+	}
+	if proxyURL := os.Getenv("SHIORI_FETCH_PROXY"); proxyURL != "" {
+		readability.Config.ProxyURL = proxyURL
+	}
+	if maxBodySize := os.Getenv("SHIORI_FETCH_MAX_BYTES"); maxBodySize != "" {
+		readability.Config.MaxBodySize, err = strconv.ParseInt(maxBodySize, 10, 64)
+		checkError(err)
+	}
+	if followRedirects := os.Getenv("SHIORI_FETCH_FOLLOW_REDIRECTS"); followRedirects != "" {
+		readability.Config.FollowRedirects, err = strconv.ParseBool(followRedirects)
+		checkError(err)
+	}
+
+	xormDB, err := dt.OpenXormDatabase(dsn, dbType, pool)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	// Record metrics of database, which served by web server in /metrics,
+	// and log the slow database calls
+	slowThreshold := dt.DefaultSlowThreshold
+	if rawSlowThreshold := os.Getenv("SHIORI_DB_SLOW_THRESHOLD"); rawSlowThreshold != "" {
+		slowThreshold, err = time.ParseDuration(rawSlowThreshold)
+		checkError(err)
+	}
+
+	db := dt.NewMetricsDatabase(xormDB)
+	db.SetLogger(logrus.StandardLogger(), slowThreshold)
 
 	// Start cmd
-	shioriCmd := cmd.NewShioriCmd(xormDB, dataDir)
-	if err := shioriCmd.Execute(); err != nil {
+	shioriCmd := cmd.NewShioriCmd(db, dataDir)
+	err = shioriCmd.Execute()
+	db.Close()
+	if err != nil {
 		logrus.Fatalln(err)
 	}
 }
@@ -7,32 +7,42 @@ import (
 
 // Tag is tag for the bookmark
 type Tag struct {
-	ID        int         `xorm:"'id' pk autoincr" json:"id"`
-	Name      string      `json:"name"`
-	Deleted   bool        `json:"-"`
-	NBookmark int         `xorm:"n_bookmarks" json:"nBookmarks"`
-	Bookmarks []*Bookmark `xorm:"-"`
-	Created   time.Time   `xorm:"created"`
-	Updated   time.Time   `xorm:"updated"`
+	ID          int         `xorm:"'id' pk autoincr" json:"id"`
+	Name        string      `json:"name"`
+	Description string      `xorm:"'description' TEXT NOT NULL DEFAULT ''" json:"description"`
+	Deleted     bool        `json:"-"`
+	NBookmark   int         `xorm:"n_bookmarks" json:"nBookmarks"`
+	Bookmarks   []*Bookmark `xorm:"-"`
+	Created     time.Time   `xorm:"created"`
+	Updated     time.Time   `xorm:"updated"`
 }
 
 // Bookmark is record of a specified URL
 type Bookmark struct {
-	ID          int       `xorm:"'id' pk autoincr" json:"id"`
-	URL         string    `xorm:"url" json:"url"`
-	Title       string    `xorm:"'title' NOT NULL" json:"title"`
-	ImageURL    string    `xorm:"'image_url' NOT NULL" json:"imageURL"`
-	Excerpt     string    `xorm:"'excerpt' NOT NULL" json:"excerpt"`
-	Author      string    `xorm:"'author' NOT NULL" json:"author"`
-	MinReadTime int       `xorm:"'min_read_time' DEFAULT 0"   json:"minReadTime"`
-	MaxReadTime int       `xorm:"'max_read_time' DEFAULT 0"   json:"maxReadTime"`
-	Modified    time.Time `xorm:"modified"    json:"modified"`
-	Content     string    `xorm:"content" json:"content"`
-	HTML        string    `xorm:"html" json:"html,omitempty"`
-	HasContent  bool      `xorm:"has_content" json:"hasContent"`
-	Tags        []Tag     `xorm:"-"           json:"tags"`
-	Created     time.Time `xorm:"created"`
-	Updated     time.Time `xorm:"updated"`
+	ID           int       `xorm:"'id' pk autoincr" json:"id"`
+	URL          string    `xorm:"url" json:"url"`
+	Title        string    `xorm:"'title' NOT NULL" json:"title"`
+	ImageURL     string    `xorm:"'image_url' NOT NULL" json:"imageURL"`
+	Excerpt      string    `xorm:"'excerpt' NOT NULL" json:"excerpt"`
+	Author       string    `xorm:"'author' NOT NULL" json:"author"`
+	MinReadTime  int       `xorm:"'min_read_time' DEFAULT 0"   json:"minReadTime"`
+	MaxReadTime  int       `xorm:"'max_read_time' DEFAULT 0"   json:"maxReadTime"`
+	Modified     time.Time `xorm:"modified"    json:"modified"`
+	Content      string    `xorm:"content" json:"content"`
+	HTML         string    `xorm:"html" json:"html,omitempty"`
+	HasContent   bool      `xorm:"has_content" json:"hasContent"`
+	Read         bool      `xorm:"'is_read' NOT NULL DEFAULT false" json:"read"`
+	Favorite     bool      `xorm:"'favorite' NOT NULL DEFAULT false" json:"favorite"`
+	Note         string    `xorm:"'note' TEXT NOT NULL DEFAULT ''" json:"note"`
+	AccountID    int       `xorm:"'account_id' INDEX NOT NULL DEFAULT 0" json:"accountID"`
+	Domain       string    `xorm:"'domain' VARCHAR(255) INDEX NOT NULL DEFAULT ''" json:"domain"`
+	Tags         []Tag     `xorm:"-"           json:"tags"`
+	HasArchive   bool      `xorm:"-"           json:"hasArchive"`
+	HasThumbnail bool      `xorm:"-"           json:"hasThumbnail"`
+	Snippet      string    `xorm:"-"           json:"snippet,omitempty"`
+	Created      time.Time `xorm:"created"     json:"created"`
+	Updated      time.Time `xorm:"updated"`
+	DeletedAt    time.Time `xorm:"'deleted_at' deleted index" json:"deletedAt"`
 }
 
 type BookmarkTag struct {
@@ -40,15 +50,78 @@ type BookmarkTag struct {
 	TagID      int `xorm:"tag_id"`
 }
 
+// Archive is the archived copy of a bookmarked page, used for offline reading
+type Archive struct {
+	BookmarkID int       `xorm:"'bookmark_id' pk"`
+	Mime       string    `xorm:"'mime' NOT NULL"`
+	Data       []byte    `xorm:"'data' BLOB"`
+	Created    time.Time `xorm:"created"`
+	Updated    time.Time `xorm:"updated"`
+}
+
+// Thumbnail is the downloaded image of a bookmark, so it's still shown
+// when the original host is down
+type Thumbnail struct {
+	BookmarkID int       `xorm:"'bookmark_id' pk"`
+	Mime       string    `xorm:"'mime' NOT NULL"`
+	Data       []byte    `xorm:"'data' BLOB"`
+	Created    time.Time `xorm:"created"`
+	Updated    time.Time `xorm:"updated"`
+}
+
 // Account is account for accessing bookmarks from web interface
 type Account struct {
 	ID       int       `xorm:"'id' pk autoincr" json:"id"`
 	Username string    `json:"username"`
 	Password string    `json:"password"`
+	IsAdmin  bool      `xorm:"'is_admin' NOT NULL DEFAULT false" json:"isAdmin"`
 	Created  time.Time `xorm:"created"`
 	Updated  time.Time `xorm:"updated"`
 }
 
+// APIToken is long-lived token that lets scripts access the API of an account.
+// Only hash of the token is stored, the token itself is shown once when it's created.
+type APIToken struct {
+	ID        int       `xorm:"'id' pk autoincr" json:"id"`
+	TokenHash string    `xorm:"'token_hash' UNIQUE NOT NULL" json:"-"`
+	AccountID int       `xorm:"'account_id' INDEX NOT NULL" json:"accountID"`
+	Label     string    `xorm:"'label' NOT NULL DEFAULT ''" json:"label"`
+	Created   time.Time `xorm:"created" json:"created"`
+}
+
+// TableName returns name of the table used to store APIToken.
+func (APIToken) TableName() string {
+	return "api_token"
+}
+
+// SchemaMigration is the record of a migration that has been applied to database.
+type SchemaMigration struct {
+	Version     int       `xorm:"'version' pk"`
+	Description string    `xorm:"'description' NOT NULL DEFAULT ''"`
+	Applied     time.Time `xorm:"'applied' created"`
+}
+
+// TableName returns name of the table used to store SchemaMigration.
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// DomainCount is the number of bookmarks saved from a domain
+type DomainCount struct {
+	Domain     string `xorm:"domain" json:"domain"`
+	NBookmarks int    `xorm:"n_bookmarks" json:"nBookmarks"`
+}
+
+// Stats is the summary of saved bookmarks, e.g. for dashboard
+type Stats struct {
+	Bookmarks    int       `json:"bookmarks"`
+	Tags         int       `json:"tags"`
+	Read         int       `json:"read"`
+	Unread       int       `json:"unread"`
+	Archived     int       `json:"archived"`
+	LastModified time.Time `json:"lastModified"`
+}
+
 // LoginRequest is login request
 type LoginRequest struct {
 	Username string `json:"username"`
@@ -0,0 +1,69 @@
+package readability
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DownloadImage downloads the image in url and returns it with its MIME type.
+// Returns an error if it's not an image or it's larger than maxSize bytes.
+// Proxy and user agent are taken from Config, but timeout is set by the caller.
+func DownloadImage(url string, maxSize int64, timeout time.Duration) ([]byte, string, error) {
+	config := Config
+	config.Timeout = timeout
+	client, err := newClient(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", config.UserAgent)
+	req.Header.Set("Accept", "image/*")
+
+	// Fetch data from URL
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("server responded with status %d", resp.StatusCode)
+	}
+
+	// Stop early if server already tells the image is too big
+	if resp.ContentLength > maxSize {
+		return nil, "", fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+
+	// The type told by server is ignored, since it may claim that any document is an image
+	mimeType := http.DetectContentType(data)
+	if !imageTypes[mimeType] {
+		return nil, "", fmt.Errorf("%s is not an image", url)
+	}
+
+	return data, mimeType, nil
+}
+
+// imageTypes are the types of images accepted by DownloadImage. Other images are rejected,
+// e.g. SVG since it may contain scripts.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
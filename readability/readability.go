@@ -0,0 +1,217 @@
+// Package readability fetches web pages and extracts their readable content into bookmark,
+// so CLI and web interface save bookmarks in the same way.
+package readability
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	nurl "net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	goreadability "github.com/go-shiori/go-readability"
+	"src.techknowlogick.com/shiori/model"
+)
+
+// UserAgent is sent when fetching pages, since some sites reject requests without one.
+const UserAgent = "Mozilla/5.0 (compatible; shiori/1.0; +https://src.techknowlogick.com/shiori)"
+
+// FetchConfig is the configuration for downloading pages.
+type FetchConfig struct {
+	Timeout         time.Duration
+	UserAgent       string
+	ProxyURL        string
+	MaxBodySize     int64
+	FollowRedirects bool
+}
+
+// DefaultFetchConfig returns the fetch configuration used when none is specified.
+// If proxy is not specified, the proxy from environment variable HTTP_PROXY is used.
+func DefaultFetchConfig() FetchConfig {
+	return FetchConfig{
+		Timeout:         20 * time.Second,
+		UserAgent:       UserAgent,
+		MaxBodySize:     10 * 1024 * 1024,
+		FollowRedirects: true,
+	}
+}
+
+// Config is the configuration used by Fetch.
+var Config = DefaultFetchConfig()
+
+// Fetch downloads the page in url and extracts it using Extract.
+// Returns an error if the server doesn't respond with status 200, or if the page is bigger
+// than the max body size. If the page is not HTML, e.g. a PDF or an image, it's not extracted
+// and the returned bookmark uses the file name as title.
+func Fetch(url string) (model.Bookmark, error) {
+	book, _, _, err := Download(url)
+	return book, err
+}
+
+// Download is like Fetch, but it also returns the downloaded content and its mime type,
+// e.g. to save a PDF as archive.
+func Download(url string) (model.Bookmark, []byte, string, error) {
+	client, err := newClient(Config)
+	if err != nil {
+		return model.Bookmark{}, nil, "", err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return model.Bookmark{}, nil, "", err
+	}
+	req.Header.Set("User-Agent", Config.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return model.Bookmark{}, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return model.Bookmark{}, nil, "", fmt.Errorf("server responded with status %d", resp.StatusCode)
+	}
+
+	// Read one more byte than allowed, to know whether the page is too big
+	body := io.Reader(resp.Body)
+	if Config.MaxBodySize > 0 {
+		body = io.LimitReader(resp.Body, Config.MaxBodySize+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return model.Bookmark{}, nil, "", err
+	}
+
+	if Config.MaxBodySize > 0 && int64(len(data)) > Config.MaxBodySize {
+		return model.Bookmark{}, nil, "", fmt.Errorf("page is bigger than %d bytes", Config.MaxBodySize)
+	}
+
+	// If server doesn't tell the content type, guess it from the content
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mimeType = "application/octet-stream"
+	}
+
+	if !IsHTML(mimeType) {
+		return model.Bookmark{URL: url, Title: fileName(url)}, data, mimeType, nil
+	}
+
+	book, err := Extract(url, bytes.NewReader(data))
+	return book, data, mimeType, err
+}
+
+// IsHTML returns whether the mime type is a web page which can be extracted.
+func IsHTML(mimeType string) bool {
+	return mimeType == "text/html" || mimeType == "application/xhtml+xml"
+}
+
+// fileName returns the name of file in url, or the url itself if it doesn't have one.
+func fileName(url string) string {
+	parsedURL, err := nurl.Parse(url)
+	if err != nil {
+		return url
+	}
+
+	name := path.Base(parsedURL.Path)
+	if name == "." || name == "/" {
+		return url
+	}
+
+	return name
+}
+
+// newClient creates HTTP client that follows the fetch configuration.
+func newClient(config FetchConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxyURL, err := nurl.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("proxy URL is not valid: %v", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	client := &http.Client{
+		Timeout:   config.Timeout,
+		Transport: &http.Transport{Proxy: proxy},
+	}
+
+	if !config.FollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return client, nil
+}
+
+// Extract parses the HTML page in r, which located in url, into bookmark.
+// Title, excerpt, author, content, HTML and image URL are filled from the page.
+// If the page doesn't have title or excerpt, e.g. because it doesn't have the meta tags,
+// its first <h1> and first paragraph are used instead.
+func Extract(url string, r io.Reader) (model.Bookmark, error) {
+	page, err := ioutil.ReadAll(r)
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return model.Bookmark{}, err
+	}
+
+	// If readability can't find the article, the fallback is still used,
+	// so the bookmark at least has title and excerpt
+	article, err := goreadability.FromReader(bytes.NewReader(page), url)
+	if err != nil {
+		article = goreadability.Article{}
+	}
+
+	book := model.Bookmark{
+		URL:      url,
+		Title:    normalizeSpace(article.Title),
+		Excerpt:  normalizeSpace(strings.Map(fixUtf, article.Excerpt)),
+		Author:   article.Byline,
+		Content:  article.TextContent,
+		HTML:     article.Content,
+		ImageURL: article.Image,
+	}
+
+	if book.Title == "" {
+		book.Title = normalizeSpace(doc.Find("h1").First().Text())
+	}
+
+	if book.Excerpt == "" {
+		book.Excerpt = normalizeSpace(strings.Map(fixUtf, doc.Find("p").First().Text()))
+	}
+
+	// Read time is left empty, so it's estimated from the content when bookmark is saved
+	book.HasContent = book.Content != ""
+
+	return book, nil
+}
+
+func normalizeSpace(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
+
+func fixUtf(r rune) rune {
+	if r == utf8.RuneError {
+		return -1
+	}
+	return r
+}
@@ -0,0 +1,168 @@
+package readability
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		file    string
+		title   string
+		excerpt string
+		author  string
+		image   string
+		content string
+	}{
+		{"article.html", "Readable article", "A short summary of the article", "Jane Doe", "https://example.com/cover.png", "full of words that read like a real text"},
+		// Without the meta tags, the first <h1> and paragraph are used
+		{"bare.html", "Bare page", "First paragraph.", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		f, err := os.Open("testdata/" + tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		book, err := Extract("https://example.com/"+tt.file, f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+
+		if book.URL != "https://example.com/"+tt.file {
+			t.Errorf("%s: got URL %s", tt.file, book.URL)
+		}
+		if book.Title != tt.title || book.Excerpt != tt.excerpt {
+			t.Errorf("%s: got title %q and excerpt %q, want %q and %q", tt.file, book.Title, book.Excerpt, tt.title, tt.excerpt)
+		}
+		if book.Author != tt.author || book.ImageURL != tt.image {
+			t.Errorf("%s: got author %q and image %q, want %q and %q", tt.file, book.Author, book.ImageURL, tt.author, tt.image)
+		}
+		if tt.content != "" && (!strings.Contains(book.Content, tt.content) || !book.HasContent) {
+			t.Errorf("%s: got content %q, want it to contain %q", tt.file, book.Content, tt.content)
+		}
+		// Database estimates the read time, so every bookmark uses the same formula
+		if book.MinReadTime != 0 || book.MaxReadTime != 0 {
+			t.Errorf("%s: got read time %d-%d, want it left to database", tt.file, book.MinReadTime, book.MaxReadTime)
+		}
+	}
+}
+
+func TestExtractDropsNavigation(t *testing.T) {
+	f, err := os.Open("testdata/article.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	book, err := Extract("https://example.com/article.html", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(book.Content, "Privacy") || strings.Contains(book.HTML, "/about") {
+		t.Errorf("got content %q, want only the article", book.Content)
+	}
+}
+
+func TestFetchConfig(t *testing.T) {
+	var userAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body><h1>Page</h1><p>Text of the page.</p></body></html>"))
+	})
+	mux.HandleFunc("/big.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(bytes.Repeat([]byte("<p>big</p>"), 200))
+	})
+	mux.Handle("/moved", http.RedirectHandler("/page.html", http.StatusFound))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	defer func(config FetchConfig) { Config = config }(Config)
+	Config = DefaultFetchConfig()
+	Config.UserAgent = "shiori-test"
+	Config.MaxBodySize = 1024
+
+	if book, err := Fetch(server.URL + "/page.html"); err != nil || book.Title != "Page" {
+		t.Errorf("got title %q and error %v, want the page", book.Title, err)
+	}
+	if userAgent != "shiori-test" {
+		t.Errorf("got User-Agent %q, want the configured one", userAgent)
+	}
+
+	tests := []struct {
+		path            string
+		followRedirects bool
+		err             string
+	}{
+		{"/big.html", true, "bigger than 1024 bytes"},
+		{"/missing.html", true, "status 404"},
+		{"/moved", false, "status 302"},
+		{"/moved", true, ""},
+	}
+
+	for _, tt := range tests {
+		Config.FollowRedirects = tt.followRedirects
+		_, err := Fetch(server.URL + tt.path)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, want %q", tt.path, err, tt.err)
+		}
+	}
+}
+
+func TestDownloadNotHTML(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docs/paper.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdf)
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		// Without this, the server sniffs the type by itself
+		w.Header()["Content-Type"] = nil
+		w.Write(pdf)
+	})
+	mux.HandleFunc("/untyped-page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("<!DOCTYPE html><html><body><h1>Untyped page</h1><p>Text.</p></body></html>"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		title    string
+		mimeType string
+	}{
+		{"/docs/paper.pdf", "paper.pdf", "application/pdf"},
+		{"/untyped", "untyped", "application/pdf"},
+		{"/untyped-page", "Untyped page", "text/html"},
+	}
+
+	for _, tt := range tests {
+		book, data, mimeType, err := Download(server.URL + tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if book.Title != tt.title || mimeType != tt.mimeType {
+			t.Errorf("%s: got title %q and type %s, want %q and %s", tt.path, book.Title, mimeType, tt.title, tt.mimeType)
+		}
+		if !IsHTML(mimeType) && (!bytes.Equal(data, pdf) || book.Content != "" || book.HTML != "") {
+			t.Errorf("%s: got data %q and content %q, want the file without extraction", tt.path, data, book.Content)
+		}
+	}
+}
@@ -111,7 +111,8 @@ new Vue({
             rest.get('/api/bookmarks', {
                     params: {
                         keyword: keyword,
-                        tags: tags.join(',')
+                        tags: tags.join(','),
+                        snippet: keyword !== ''
                     }
                 })
                 .then((response) => {
@@ -120,7 +121,12 @@ new Vue({
                     this.maxPage = Math.ceil(this.bookmarks.length / pageSize) - 1;
                     window.scrollTo(0, 0);
 
-                    return rest.get('/api/tags');
+                    return rest.get('/api/tags', {
+                        params: {
+                            keyword: keyword,
+                            tags: tags.join(',')
+                        }
+                    });
                 })
                 .then((response) => {
                     this.tags = response.data;
@@ -512,8 +518,12 @@ new Vue({
                 mainText: 'Yes',
                 secondText: 'No',
                 mainClick: () => {
-                    Cookies.remove('token');
-                    location.href = '/login';
+                    rest.post('/api/logout')
+                        .catch(() => {})
+                        .then(() => {
+                            Cookies.remove('token');
+                            location.href = '/login';
+                        });
                 }
             });
         },